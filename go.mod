@@ -3,9 +3,10 @@ module github.com/rumis/storage
 go 1.16
 
 require (
-	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
-	github.com/alicebob/miniredis/v2 v2.21.0 // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
-	github.com/rumis/seal v0.0.0-20220817024526-04cbff1276d5 // indirect
-	github.com/segmentio/kafka-go v0.4.32 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/alicebob/miniredis/v2 v2.21.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/mattn/go-sqlite3 v1.14.12
+	github.com/rumis/seal v0.0.0-20220817024526-04cbff1276d5
+	github.com/segmentio/kafka-go v0.4.32
 )
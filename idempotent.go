@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/pkg/ujson"
+	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
+)
+
+// IdempotentState 幂等请求状态
+type IdempotentState int8
+
+const (
+	IdempotentStateProcessing IdempotentState = 1 // 处理中
+	IdempotentStateCompleted  IdempotentState = 2 // 已完成
+)
+
+// IdempotentConflict 并发重复请求处理方式
+type IdempotentConflict int8
+
+const (
+	IdempotentConflictReject IdempotentConflict = 1 // 直接拒绝
+	IdempotentConflictWait   IdempotentConflict = 2 // 等待首个请求完成后返回其结果
+)
+
+var defaultIdempotentPrefix = "tal_jiaoyan_storage_idempotent_"
+
+// DefaultIdempotentProcessingTTL 处理中状态默认超时时间 30s
+var DefaultIdempotentProcessingTTL time.Duration = time.Second * 30
+
+// DefaultIdempotentResultTTL 结果默认保存时间 24h
+var DefaultIdempotentResultTTL time.Duration = time.Hour * 24
+
+// DefaultIdempotentRetrySpan 等待模式默认轮询间隔 50ms
+var DefaultIdempotentRetrySpan time.Duration = time.Millisecond * 50
+
+// DefaultIdempotentCleanupTimeout 处理失败时清除记录的超时时间，不受调用方ctx取消影响 1s
+var DefaultIdempotentCleanupTimeout time.Duration = time.Second
+
+// 错误定义
+var ErrIdempotentKeyNil error = serr.New(serr.ErrInvalid, "idempotent key is nil")
+var ErrIdempotentInProgress error = serr.New(serr.ErrConflict, "idempotent request is in progress")
+
+// IdempotentKeyGenerator 幂等KEY生成
+type IdempotentKeyGenerator func(ctx context.Context, params interface{}) (string, error)
+
+// IdempotentResultDecoder 重放时结果反序列化
+type IdempotentResultDecoder func(data []byte) (interface{}, error)
+
+// idempotentRecord 幂等记录
+type idempotentRecord struct {
+	State  IdempotentState   `json:"state"`
+	Token  string            `json:"token,omitempty"` // 处理中记录的抢占标识，完成或清除时据此确认记录仍属于本次处理
+	Status meta.OptionStatus `json:"status,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+}
+
+// IdempotentOptions 幂等配置
+type IdempotentOptions struct {
+	KeyFn         IdempotentKeyGenerator
+	ResultDecoder IdempotentResultDecoder
+	Conflict      IdempotentConflict
+	ProcessingTTL time.Duration
+	ResultTTL     time.Duration
+	RetrySpan     time.Duration // 等待模式轮询间隔
+	WaitTimeout   time.Duration // 等待模式最长等待时间，为0时等待至ctx结束
+	RedisOptions  []scache.RedisOptionHandler
+}
+
+// IdempotentOptionHandler 幂等配置选项
+type IdempotentOptionHandler func(*IdempotentOptions)
+
+// DefaultIdempotentOptions 创建默认幂等配置
+// 默认使用参数的Key()作为幂等KEY，结果以json.RawMessage返回
+func DefaultIdempotentOptions() IdempotentOptions {
+	return IdempotentOptions{
+		KeyFn: func(ctx context.Context, params interface{}) (string, error) {
+			k, ok := params.(meta.Key)
+			if !ok || k.Key() == "" {
+				return "", ErrIdempotentKeyNil
+			}
+			return k.Key(), nil
+		},
+		ResultDecoder: func(data []byte) (interface{}, error) {
+			return json.RawMessage(data), nil
+		},
+		Conflict:      IdempotentConflictReject,
+		ProcessingTTL: DefaultIdempotentProcessingTTL,
+		ResultTTL:     DefaultIdempotentResultTTL,
+		RetrySpan:     DefaultIdempotentRetrySpan,
+		RedisOptions:  []scache.RedisOptionHandler{scache.WithClient(scache.DefaultClient()), scache.WithPrefix(defaultIdempotentPrefix)},
+	}
+}
+
+// WithIdempotentKeyFn 幂等KEY生成
+func WithIdempotentKeyFn(fn IdempotentKeyGenerator) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.KeyFn = fn
+	}
+}
+
+// WithIdempotentResultDecoder 结果反序列化
+func WithIdempotentResultDecoder(fn IdempotentResultDecoder) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.ResultDecoder = fn
+	}
+}
+
+// WithIdempotentResultType 重放时将结果反序列化为与sample相同的类型，使重放与首次执行返回相同类型的结果
+// sample为处理方法返回值同类型的值，如Order{}或&Order{}
+func WithIdempotentResultType(sample interface{}) IdempotentOptionHandler {
+	t := reflect.TypeOf(sample)
+	return WithIdempotentResultDecoder(func(data []byte) (interface{}, error) {
+		if t == nil {
+			return json.RawMessage(data), nil
+		}
+		v := reflect.New(t)
+		err := ujson.Unmarshal(data, v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	})
+}
+
+// WithIdempotentConflict 并发重复请求处理方式
+func WithIdempotentConflict(c IdempotentConflict) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.Conflict = c
+	}
+}
+
+// WithIdempotentTTL 处理中状态超时时间及结果保存时间
+func WithIdempotentTTL(processing time.Duration, result time.Duration) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.ProcessingTTL = processing
+		opts.ResultTTL = result
+	}
+}
+
+// WithIdempotentWait 等待模式的轮询间隔及最长等待时间
+func WithIdempotentWait(span time.Duration, timeout time.Duration) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.RetrySpan = span
+		opts.WaitTimeout = timeout
+	}
+}
+
+// WithIdempotentRedisOptions Redis配置，支持scache的Client、Prefix、ExecLogger
+func WithIdempotentRedisOptions(hands ...scache.RedisOptionHandler) IdempotentOptionHandler {
+	return func(opts *IdempotentOptions) {
+		opts.RedisOptions = append(opts.RedisOptions, hands...)
+	}
+}
+
+// NewIdempotentHandler 为数据处理方法增加幂等保护
+// 首个请求执行处理方法并保存结果，重放请求直接返回保存的结果，
+// 处理中的重复请求按Conflict配置拒绝或等待，处理失败时清除记录以便重试
+// 重放的结果由ResultDecoder生成，默认为json.RawMessage，需要与首次执行相同的类型时使用WithIdempotentResultType
+// 处理成功但结果保存失败时仍返回处理结果，错误由Redis的ExecLogger记录，处理中的记录保留至ProcessingTTL到期，期间的重复请求不会再次执行
+// 结果保存及失败清除只作用于本次抢占的记录，处理超过ProcessingTTL被其他请求接管后不会覆盖或删除对方的记录
+func NewIdempotentHandler(h DataHandler, hands ...IdempotentOptionHandler) DataHandler {
+	// 默认配置
+	opts := DefaultIdempotentOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	claimer := scache.NewReaderSetNX(opts.RedisOptions...)
+	writer := scache.NewRedisKeyValueCompareWriter(opts.RedisOptions...)
+	reader := scache.NewRedisKeyValueStringReader(opts.RedisOptions...)
+	deleter := scache.NewRedisKeyValueCompareDeleter(opts.RedisOptions...)
+	// 失败时清除本次抢占的记录，使用独立的ctx，避免调用方ctx已取消时记录残留至ProcessingTTL到期
+	cleanup := func(key string, processing string) {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultIdempotentCleanupTimeout)
+		defer cancel()
+		deleter(ctx, scache.Pair{Key: key, Value: processing})
+	}
+
+	return func(ctx context.Context, params interface{}) (interface{}, meta.OptionStatus, error) {
+		key, err := opts.KeyFn(ctx, params)
+		if err != nil {
+			return nil, meta.OptionStatusBreak, err
+		}
+		var deadline time.Time
+		if opts.WaitTimeout > 0 {
+			deadline = time.Now().Add(opts.WaitTimeout)
+		}
+		for {
+			// 抢占处理权，每次抢占使用不同的标识
+			token, err := idempotentToken()
+			if err != nil {
+				return nil, meta.OptionStatusBreak, err
+			}
+			processing, _ := ujson.Marshal(idempotentRecord{State: IdempotentStateProcessing, Token: token})
+			if claimer(ctx, scache.Pair{Key: key, Value: string(processing)}, opts.ProcessingTTL) {
+				res, stat, err := h(ctx, params)
+				if err != nil {
+					cleanup(key, string(processing))
+					return res, stat, err
+				}
+				buf, err := ujson.Marshal(res)
+				if err != nil {
+					cleanup(key, string(processing))
+					return nil, meta.OptionStatusBreak, err
+				}
+				record, _ := ujson.Marshal(idempotentRecord{State: IdempotentStateCompleted, Status: stat, Result: buf})
+				// 处理已完成，保存失败不影响本次结果
+				writer(ctx, scache.Pair{Key: key, Value: string(record)}, string(processing), opts.ResultTTL)
+				return res, stat, nil
+			}
+			// 读取已有记录
+			val, err := reader(ctx, key)
+			if err != nil && !errors.Is(err, serr.ErrNotFound) {
+				return nil, meta.OptionStatusBreak, err
+			}
+			if err == nil {
+				var record idempotentRecord
+				str, _ := val.(string)
+				err = ujson.Unmarshal([]byte(str), &record)
+				if err != nil {
+					return nil, meta.OptionStatusBreak, err
+				}
+				if record.State == IdempotentStateCompleted {
+					res, err := opts.ResultDecoder(record.Result)
+					return res, record.Status, err
+				}
+				if opts.Conflict == IdempotentConflictReject {
+					return nil, meta.OptionStatusBreak, ErrIdempotentInProgress
+				}
+			}
+			// 等待首个请求完成，记录被清除时重新抢占
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return nil, meta.OptionStatusBreak, ErrIdempotentInProgress
+			}
+			select {
+			case <-ctx.Done():
+				return nil, meta.OptionStatusBreak, ctx.Err()
+			case <-time.After(opts.RetrySpan):
+			}
+		}
+	}
+}
+
+// idempotentToken 生成处理中记录的抢占标识
+func idempotentToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
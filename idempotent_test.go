@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/pkg/ujson"
+	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
+)
+
+type createOrder struct {
+	RequestID string `json:"request_id"`
+	Amount    int    `json:"amount"`
+}
+
+func (c createOrder) Key() string {
+	return c.RequestID
+}
+
+// failSetHook 模拟Redis写入失败，SETNX正常执行
+type failSetHook struct{}
+
+func (failSetHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "evalsha" || cmd.Name() == "eval" {
+		return ctx, errors.New("set failed")
+	}
+	return ctx, nil
+}
+
+func (failSetHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (failSetHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (failSetHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestIdempotentHandler(t *testing.T) {
+
+	ctx := context.TODO()
+
+	// 启动内存Redis服务并创建Client
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+
+	var calls int32
+	insert := func(ctx context.Context, params interface{}) (interface{}, meta.OptionStatus, error) {
+		n := atomic.AddInt32(&calls, 1)
+		req := params.(createOrder)
+		if req.Amount < 0 {
+			return nil, meta.OptionStatusBreak, errors.New("amount invalid")
+		}
+		time.Sleep(time.Millisecond * 50)
+		return int64(n), meta.OptionStatusContinue, nil
+	}
+	decoder := WithIdempotentResultDecoder(func(data []byte) (interface{}, error) {
+		var id int64
+		err := ujson.Unmarshal(data, &id)
+		return id, err
+	})
+	h := NewIdempotentHandler(insert, WithIdempotentRedisOptions(scache.WithClient(rClient)), decoder)
+
+	// 首次执行
+	res, stat, err := h(ctx, createOrder{RequestID: "r1", Amount: 10})
+	if err != nil || res.(int64) != 1 || stat != meta.OptionStatusContinue {
+		t.Fatal(res, stat, err)
+	}
+	// 重放返回保存的结果
+	res, stat, err = h(ctx, createOrder{RequestID: "r1", Amount: 10})
+	if err != nil || res.(int64) != 1 || stat != meta.OptionStatusContinue {
+		t.Fatal(res, stat, err)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("handler should run once")
+	}
+
+	// 处理失败后允许重试
+	_, _, err = h(ctx, createOrder{RequestID: "r2", Amount: -1})
+	if err == nil {
+		t.Fatal("should return handler error")
+	}
+	if server.Exists(defaultIdempotentPrefix + "r2") {
+		t.Fatal("failed record should be removed")
+	}
+
+	// 缺少幂等KEY
+	_, _, err = h(ctx, createOrder{Amount: 1})
+	if err != ErrIdempotentKeyNil || !errors.Is(err, serr.ErrInvalid) {
+		t.Fatal(err)
+	}
+
+	// 并发重复请求，拒绝模式
+	var wg sync.WaitGroup
+	var rejected int32
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := h(ctx, createOrder{RequestID: "r3", Amount: 1})
+			if err == ErrIdempotentInProgress {
+				atomic.AddInt32(&rejected, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if rejected != 2 || !errors.Is(ErrIdempotentInProgress, serr.ErrConflict) {
+		t.Fatal("concurrent duplicates should be rejected", rejected)
+	}
+
+	// 并发重复请求，等待模式返回相同结果
+	hw := NewIdempotentHandler(insert,
+		WithIdempotentRedisOptions(scache.WithClient(rClient)),
+		WithIdempotentConflict(IdempotentConflictWait),
+		WithIdempotentWait(time.Millisecond*10, time.Second))
+	results := make([]interface{}, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, _, err := hw(ctx, createOrder{RequestID: "r4", Amount: 1})
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = res
+		}(i)
+	}
+	wg.Wait()
+	first, _ := json.Marshal(results[0])
+	for _, r := range results[1:] {
+		buf, _ := json.Marshal(r)
+		if string(buf) != string(first) {
+			t.Fatal("waiting duplicates should get the same result", results)
+		}
+	}
+
+	// 重放时返回与首次执行相同类型的结果
+	type order struct {
+		ID     int64 `json:"id"`
+		Amount int   `json:"amount"`
+	}
+	ht := NewIdempotentHandler(func(ctx context.Context, params interface{}) (interface{}, meta.OptionStatus, error) {
+		return &order{ID: 7, Amount: params.(createOrder).Amount}, meta.OptionStatusContinue, nil
+	}, WithIdempotentRedisOptions(scache.WithClient(rClient)), WithIdempotentResultType(&order{}))
+	for i := 0; i < 2; i++ {
+		res, _, err = ht(ctx, createOrder{RequestID: "r5", Amount: 3})
+		o, ok := res.(*order)
+		if err != nil || !ok || o.ID != 7 || o.Amount != 3 {
+			t.Fatal(res, err)
+		}
+	}
+
+	// 结果保存失败时返回处理结果，处理中的记录保留，重复请求不再执行
+	fClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+	fClient.AddHook(failSetHook{})
+	hf := NewIdempotentHandler(insert, WithIdempotentRedisOptions(scache.WithClient(fClient)), decoder)
+	calls = 0
+	res, stat, err = hf(ctx, createOrder{RequestID: "r6", Amount: 1})
+	if err != nil || res.(int64) != 1 || stat != meta.OptionStatusContinue {
+		t.Fatal(res, stat, err)
+	}
+	_, _, err = hf(ctx, createOrder{RequestID: "r6", Amount: 1})
+	if err != ErrIdempotentInProgress || atomic.LoadInt32(&calls) != 1 {
+		t.Fatal(calls, err)
+	}
+
+	// 处理超时被其他请求接管，完成及失败时不覆盖、不删除对方的记录
+	taken := `{"state":1,"token":"other"}`
+	takeover := NewIdempotentHandler(func(ctx context.Context, params interface{}) (interface{}, meta.OptionStatus, error) {
+		server.Set(defaultIdempotentPrefix+params.(createOrder).RequestID, taken)
+		if params.(createOrder).Amount < 0 {
+			return nil, meta.OptionStatusBreak, errors.New("amount invalid")
+		}
+		return int64(1), meta.OptionStatusContinue, nil
+	}, WithIdempotentRedisOptions(scache.WithClient(rClient)))
+	_, _, err = takeover(ctx, createOrder{RequestID: "r7", Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := server.Get(defaultIdempotentPrefix + "r7"); val != taken {
+		t.Fatal("completion should not overwrite a taken over record", val)
+	}
+	_, _, err = takeover(ctx, createOrder{RequestID: "r8", Amount: -1})
+	if err == nil {
+		t.Fatal("should return handler error")
+	}
+	if val, _ := server.Get(defaultIdempotentPrefix + "r8"); val != taken {
+		t.Fatal("cleanup should not delete a taken over record", val)
+	}
+
+	// 调用方ctx取消后仍清除失败的记录
+	cctx, cancel := context.WithCancel(ctx)
+	hc := NewIdempotentHandler(func(ctx context.Context, params interface{}) (interface{}, meta.OptionStatus, error) {
+		cancel()
+		return nil, meta.OptionStatusBreak, ctx.Err()
+	}, WithIdempotentRedisOptions(scache.WithClient(rClient)))
+	_, _, err = hc(cctx, createOrder{RequestID: "r9", Amount: 1})
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+	if server.Exists(defaultIdempotentPrefix + "r9") {
+		t.Fatal("failed record should be removed after ctx canceled")
+	}
+}
@@ -0,0 +1,301 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
+)
+
+// DefaultLeaseDuration 默认租约时长 15s
+var DefaultLeaseDuration time.Duration = time.Second * 15
+
+// DefaultRenewPeriod 默认续约间隔 5s
+var DefaultRenewPeriod time.Duration = time.Second * 5
+
+// DefaultRetryPeriod 默认竞选重试间隔 2s
+var DefaultRetryPeriod time.Duration = time.Second * 2
+
+var defaultLeaderPrefix = "tal_jiaoyan_storage_leader_"
+
+// 错误定义
+var ErrIdentityNil error = serr.New(serr.ErrInvalid, "leader identity is nil")
+var ErrLeaseInvalid error = serr.New(serr.ErrInvalid, "renew period must be less than lease duration")
+
+// renewScript 当前仍为leader时续约
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript 当前仍为leader时释放
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ElectorOptions 选主配置
+type ElectorOptions struct {
+	Identity         string
+	LeaseDuration    time.Duration
+	RenewPeriod      time.Duration
+	RetryPeriod      time.Duration
+	OnStartedLeading func(ctx context.Context) // 成为leader后调用，失去leader时ctx被取消
+	OnStoppedLeading func()                    // 失去leader且OnStartedLeading退出后调用
+	OnNewLeader      func(identity string)     // 观察到leader变化时调用
+	RedisOptions     []scache.RedisOptionHandler
+}
+
+// ElectorOptionHandler 选主配置选项
+type ElectorOptionHandler func(*ElectorOptions)
+
+// DefaultElectorOptions 创建默认的选主配置，Identity默认为hostname_pid
+func DefaultElectorOptions() ElectorOptions {
+	host, _ := os.Hostname()
+	return ElectorOptions{
+		Identity:      host + "_" + strconv.Itoa(os.Getpid()),
+		LeaseDuration: DefaultLeaseDuration,
+		RenewPeriod:   DefaultRenewPeriod,
+		RetryPeriod:   DefaultRetryPeriod,
+		RedisOptions:  []scache.RedisOptionHandler{scache.WithClient(scache.DefaultClient())},
+	}
+}
+
+// WithIdentity 当前实例标识
+func WithIdentity(id string) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.Identity = id
+	}
+}
+
+// WithLeaseDuration 租约时长
+func WithLeaseDuration(d time.Duration) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.LeaseDuration = d
+	}
+}
+
+// WithRenewPeriod 续约间隔
+func WithRenewPeriod(d time.Duration) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.RenewPeriod = d
+	}
+}
+
+// WithRetryPeriod 竞选重试间隔
+func WithRetryPeriod(d time.Duration) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.RetryPeriod = d
+	}
+}
+
+// WithOnStartedLeading 成为leader回调
+func WithOnStartedLeading(fn func(ctx context.Context)) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.OnStartedLeading = fn
+	}
+}
+
+// WithOnStoppedLeading 失去leader回调
+func WithOnStoppedLeading(fn func()) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.OnStoppedLeading = fn
+	}
+}
+
+// WithOnNewLeader leader变化回调
+func WithOnNewLeader(fn func(identity string)) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.OnNewLeader = fn
+	}
+}
+
+// WithRedisOptions Redis配置，支持scache的Client、Prefix、ExecLogger
+func WithRedisOptions(hands ...scache.RedisOptionHandler) ElectorOptionHandler {
+	return func(opts *ElectorOptions) {
+		opts.RedisOptions = append(opts.RedisOptions, hands...)
+	}
+}
+
+// Elector 基于Redis锁的选主
+type Elector struct {
+	key      string
+	opts     ElectorOptions
+	redis    scache.RedisOptions
+	campaign scache.RedisKeyValueNX
+	reader   scache.RedisKeyValueStringReader
+
+	mu         sync.RWMutex
+	isLeader   bool
+	lastLeader string
+}
+
+// NewElector 创建新的选主对象
+// @params name 选主名称，同名的Elector竞争同一个leader
+func NewElector(name string, hands ...ElectorOptionHandler) *Elector {
+	// 默认配置
+	opts := DefaultElectorOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	ropts := scache.DefaultRedisOptions()
+	for _, fn := range opts.RedisOptions {
+		fn(&ropts)
+	}
+	if ropts.Prefix == "" {
+		ropts.Prefix = defaultLeaderPrefix
+	}
+	ropt := func(o *scache.RedisOptions) {
+		*o = ropts
+	}
+	return &Elector{
+		key:      name,
+		opts:     opts,
+		redis:    ropts,
+		campaign: scache.NewReaderSetNX(ropt),
+		reader:   scache.NewRedisKeyValueStringReader(ropt),
+	}
+}
+
+// IsLeader 当前实例是否为leader
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader
+}
+
+// Leader 获取当前leader标识，没有leader时返回空字符串
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	res, err := e.reader(ctx, e.key)
+	if errors.Is(err, serr.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	id, _ := res.(string)
+	return id, nil
+}
+
+// Run 参与选主，阻塞直至ctx结束
+// 成为leader后定期续约，失去leader后继续参与竞选
+func (e *Elector) Run(ctx context.Context) error {
+	if e.opts.Identity == "" {
+		return ErrIdentityNil
+	}
+	if e.opts.RenewPeriod >= e.opts.LeaseDuration {
+		return ErrLeaseInvalid
+	}
+	if e.redis.Client == nil {
+		return scache.ErrClientNil
+	}
+	for {
+		// 租约从发起竞选时开始计算
+		startTime := time.Now()
+		if e.campaign(ctx, scache.Pair{Key: e.key, Value: e.opts.Identity}, e.opts.LeaseDuration) {
+			e.lead(ctx, startTime)
+		} else {
+			e.observe(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(e.opts.RetryPeriod):
+		}
+	}
+}
+
+// observe 观察当前leader
+func (e *Elector) observe(ctx context.Context) {
+	id, err := e.Leader(ctx)
+	if err != nil || id == "" {
+		return
+	}
+	e.notifyLeader(id)
+}
+
+// notifyLeader leader变化时回调
+func (e *Elector) notifyLeader(id string) {
+	e.mu.Lock()
+	changed := e.lastLeader != id
+	e.lastLeader = id
+	e.mu.Unlock()
+	if changed && e.opts.OnNewLeader != nil {
+		e.opts.OnNewLeader(id)
+	}
+}
+
+// lead 担任leader直至租约丢失或ctx结束，lastRenew为获得租约的时间
+func (e *Elector) lead(ctx context.Context, lastRenew time.Time) {
+	leadCtx, cancel := context.WithCancel(ctx)
+	e.mu.Lock()
+	e.isLeader = true
+	e.mu.Unlock()
+	e.notifyLeader(e.opts.Identity)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if e.opts.OnStartedLeading != nil {
+			e.opts.OnStartedLeading(leadCtx)
+		}
+	}()
+
+	ticker := time.NewTicker(e.opts.RenewPeriod)
+	for leading := true; leading; {
+		select {
+		case <-ctx.Done():
+			// 主动退出，释放leader
+			e.release(context.Background())
+			leading = false
+		case <-ticker.C:
+			ok, err := e.renew(leadCtx)
+			if ok {
+				lastRenew = time.Now()
+				continue
+			}
+			// Redis异常时继续尝试，下次续约前租约可能到期时退出，避免租约到期后仍以leader身份运行
+			if err != nil && time.Since(lastRenew)+e.opts.RenewPeriod < e.opts.LeaseDuration {
+				continue
+			}
+			leading = false
+		}
+	}
+	ticker.Stop()
+
+	e.mu.Lock()
+	e.isLeader = false
+	e.mu.Unlock()
+	cancel()
+	<-done
+	if e.opts.OnStoppedLeading != nil {
+		e.opts.OnStoppedLeading()
+	}
+}
+
+// renew 续约
+func (e *Elector) renew(ctx context.Context) (bool, error) {
+	startTime := time.Now()
+	cmd := renewScript.Run(ctx, e.redis.Client, []string{e.redis.Prefix + e.key}, e.opts.Identity, e.opts.LeaseDuration.Milliseconds())
+	res, err := cmd.Int64()
+	scache.ExecLogError(ctx, e.redis.ExecLogFn, startTime, cmd.String(), err)
+	return res == 1, err
+}
+
+// release 释放leader
+func (e *Elector) release(ctx context.Context) {
+	startTime := time.Now()
+	cmd := releaseScript.Run(ctx, e.redis.Client, []string{e.redis.Prefix + e.key}, e.opts.Identity)
+	scache.ExecLogError(ctx, e.redis.ExecLogFn, startTime, cmd.String(), cmd.Err())
+}
@@ -0,0 +1,147 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
+)
+
+func TestElector(t *testing.T) {
+
+	// 启动内存Redis服务并创建Client
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+
+	var started, stopped int32
+	newElector := func(id string) *Elector {
+		return NewElector("cache_warmer",
+			WithIdentity(id),
+			WithLeaseDuration(time.Second),
+			WithRenewPeriod(time.Millisecond*20),
+			WithRetryPeriod(time.Millisecond*20),
+			WithRedisOptions(scache.WithClient(rClient)),
+			WithOnStartedLeading(func(ctx context.Context) {
+				atomic.AddInt32(&started, 1)
+				<-ctx.Done()
+			}),
+			WithOnStoppedLeading(func() {
+				atomic.AddInt32(&stopped, 1)
+			}),
+		)
+	}
+	waitFor := func(msg string, fn func() bool) {
+		deadline := time.Now().Add(time.Second * 2)
+		for !fn() {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for", msg)
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+	}
+
+	// 配置不合法时不参与竞选
+	err = newElector("").Run(context.Background())
+	if err != ErrIdentityNil || !errors.Is(err, serr.ErrInvalid) {
+		t.Fatal(err)
+	}
+	err = NewElector("cache_warmer", WithIdentity("pod1"), WithRenewPeriod(time.Second), WithLeaseDuration(time.Second)).Run(context.Background())
+	if err != ErrLeaseInvalid || !errors.Is(err, serr.ErrInvalid) {
+		t.Fatal(err)
+	}
+
+	e1 := newElector("pod1")
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	go e1.Run(ctx1)
+	waitFor("pod1 leading", e1.IsLeader)
+
+	var observed atomic.Value
+	e2 := newElector("pod2")
+	e2.opts.OnNewLeader = func(id string) { observed.Store(id) }
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go e2.Run(ctx2)
+	waitFor("pod2 observe pod1", func() bool { return observed.Load() == "pod1" })
+	if e2.IsLeader() {
+		t.Fatal("pod2 should not be leader")
+	}
+	id, err := e2.Leader(context.Background())
+	if err != nil || id != "pod1" {
+		t.Fatal(id, err)
+	}
+
+	// pod1退出，pod2接管
+	cancel1()
+	waitFor("pod2 leading", e2.IsLeader)
+	waitFor("pod1 stopped", func() bool { return atomic.LoadInt32(&stopped) == 1 })
+
+	// 租约被其他实例占用，pod2失去leader
+	server.Set(defaultLeaderPrefix+"cache_warmer", "pod3")
+	waitFor("pod2 lost", func() bool { return !e2.IsLeader() })
+	waitFor("pod2 stopped", func() bool { return atomic.LoadInt32(&stopped) == 2 })
+	if atomic.LoadInt32(&started) != 2 {
+		t.Fatal("started count error", started)
+	}
+}
+
+func TestElectorRedisDown(t *testing.T) {
+
+	// 启动内存Redis服务并创建Client
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+
+	var stopped int32
+	e := NewElector("cache_warmer",
+		WithIdentity("pod1"),
+		WithLeaseDuration(time.Millisecond*400),
+		WithRenewPeriod(time.Millisecond*150),
+		WithRetryPeriod(time.Millisecond*20),
+		WithRedisOptions(scache.WithClient(rClient)),
+		WithOnStoppedLeading(func() {
+			atomic.StoreInt32(&stopped, 1)
+		}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	deadline := time.Now().Add(time.Second)
+	for !e.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for leading")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	// Redis不可用，租约到期前必须退出leader
+	leadTime := time.Now()
+	server.SetError("ERR redis down")
+	for atomic.LoadInt32(&stopped) == 0 {
+		if time.Since(leadTime) > time.Second {
+			t.Fatal("timeout waiting for stopped")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if time.Since(leadTime) >= time.Millisecond*400 {
+		t.Fatal("leader should step down before lease expires", time.Since(leadTime))
+	}
+	if e.IsLeader() {
+		t.Fatal("pod1 should not be leader")
+	}
+}
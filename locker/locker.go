@@ -20,26 +20,32 @@ type LockerAdder func(ctx context.Context, key string) bool
 // LockerDeleter 删除锁
 type LockerDeleter func(ctx context.Context, key string) error
 
+// LockerFencingAdder 加锁并返回fencing token
+// 加锁成功时token单调递增，写库时携带token可拒绝过期持有者的写入
+type LockerFencingAdder func(ctx context.Context, key string) (int64, bool)
+
 // LockerOptionHandler 读取锁配置选项
 type LockerOptionHandler func(*Locker)
 
 // Locker 数据库读锁
 type Locker struct {
-	Adder      LockerAdder
-	Deleter    LockerDeleter
-	Expire     time.Duration
-	RetryTimes int
-	RetrySpan  time.Duration
+	Adder        LockerAdder
+	FencingAdder LockerFencingAdder
+	Deleter      LockerDeleter
+	Expire       time.Duration
+	RetryTimes   int
+	RetrySpan    time.Duration
 }
 
 // DefaultLocker 创建默认Locker对象
 func DefaultLocker() Locker {
 	return Locker{
-		Adder:      func(ctx context.Context, key string) bool { return false },
-		Deleter:    func(ctx context.Context, key string) error { return nil },
-		Expire:     DefaultExpire,
-		RetryTimes: DefaultRetryTimes,
-		RetrySpan:  DefaultRetrySpan,
+		Adder:        func(ctx context.Context, key string) bool { return false },
+		FencingAdder: func(ctx context.Context, key string) (int64, bool) { return 0, false },
+		Deleter:      func(ctx context.Context, key string) error { return nil },
+		Expire:       DefaultExpire,
+		RetryTimes:   DefaultRetryTimes,
+		RetrySpan:    DefaultRetrySpan,
 	}
 }
 
@@ -59,6 +65,13 @@ func WithLockerAdder(a LockerAdder) LockerOptionHandler {
 	}
 }
 
+// WithLockerFencingAdder 加锁，返回fencing token
+func WithLockerFencingAdder(a LockerFencingAdder) LockerOptionHandler {
+	return func(opts *Locker) {
+		opts.FencingAdder = a
+	}
+}
+
 // WithLockerDeleter 设置locker删除
 func WithLockerDeleter(d LockerDeleter) LockerOptionHandler {
 	return func(opts *Locker) {
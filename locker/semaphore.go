@@ -0,0 +1,140 @@
+package locker
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultSemaphoreLimit 默认并发数 1
+var DefaultSemaphoreLimit int64 = 1
+
+// DefaultSemaphoreExpire 默认持有超时时间 30s
+var DefaultSemaphoreExpire time.Duration = time.Second * 30
+
+// SemaphoreAcquirer 获取信号量
+// @params holder 持有者标识，同一key下需唯一
+// @params limit 最大持有者数量
+// @params expire 持有超时时间，超时未续期的持有者会被自动清理
+type SemaphoreAcquirer func(ctx context.Context, key string, holder string, limit int64, expire time.Duration) bool
+
+// SemaphoreReleaser 释放信号量
+type SemaphoreReleaser func(ctx context.Context, key string, holder string) error
+
+// SemaphoreRefresher 信号量续期，持有已失效时返回false
+type SemaphoreRefresher func(ctx context.Context, key string, holder string, expire time.Duration) bool
+
+// SemaphoreOptionHandler 信号量配置选项
+type SemaphoreOptionHandler func(*Semaphore)
+
+// Semaphore 分布式计数信号量
+type Semaphore struct {
+	Acquirer   SemaphoreAcquirer
+	Releaser   SemaphoreReleaser
+	Refresher  SemaphoreRefresher
+	Limit      int64
+	Expire     time.Duration
+	RetryTimes int
+	RetrySpan  time.Duration
+}
+
+// DefaultSemaphore 创建默认Semaphore对象
+func DefaultSemaphore() Semaphore {
+	return Semaphore{
+		Acquirer: func(ctx context.Context, key string, holder string, limit int64, expire time.Duration) bool {
+			return false
+		},
+		Releaser: func(ctx context.Context, key string, holder string) error { return nil },
+		Refresher: func(ctx context.Context, key string, holder string, expire time.Duration) bool {
+			return false
+		},
+		Limit:      DefaultSemaphoreLimit,
+		Expire:     DefaultSemaphoreExpire,
+		RetryTimes: DefaultRetryTimes,
+		RetrySpan:  DefaultRetrySpan,
+	}
+}
+
+// NewSemaphore 创建新Semaphore对象
+func NewSemaphore(opts ...SemaphoreOptionHandler) Semaphore {
+	s := DefaultSemaphore()
+	for _, fn := range opts {
+		fn(&s)
+	}
+	return s
+}
+
+// Acquire 获取信号量，失败时按RetryTimes、RetrySpan重试
+func (s Semaphore) Acquire(ctx context.Context, key string, holder string) bool {
+	for i := 0; ; i++ {
+		if s.Acquirer(ctx, key, holder, s.Limit, s.Expire) {
+			return true
+		}
+		if i >= s.RetryTimes {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(s.RetrySpan):
+		}
+	}
+}
+
+// Release 释放信号量
+func (s Semaphore) Release(ctx context.Context, key string, holder string) error {
+	return s.Releaser(ctx, key, holder)
+}
+
+// Refresh 信号量续期
+func (s Semaphore) Refresh(ctx context.Context, key string, holder string) bool {
+	return s.Refresher(ctx, key, holder, s.Expire)
+}
+
+// WithSemaphoreAcquirer 设置信号量获取
+func WithSemaphoreAcquirer(a SemaphoreAcquirer) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.Acquirer = a
+	}
+}
+
+// WithSemaphoreReleaser 设置信号量释放
+func WithSemaphoreReleaser(r SemaphoreReleaser) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.Releaser = r
+	}
+}
+
+// WithSemaphoreRefresher 设置信号量续期
+func WithSemaphoreRefresher(r SemaphoreRefresher) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.Refresher = r
+	}
+}
+
+// WithSemaphoreLimit 设置最大并发数
+func WithSemaphoreLimit(l int64) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.Limit = l
+	}
+}
+
+// WithSemaphoreExpire 设置持有超时时间
+func WithSemaphoreExpire(e time.Duration) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.Expire = e
+	}
+}
+
+// WithSemaphoreRetryTimes 设置获取失败重试次数
+func WithSemaphoreRetryTimes(rt int) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.RetryTimes = rt
+	}
+}
+
+// WithSemaphoreRetrySpan 设置获取失败重试间隔
+func WithSemaphoreRetrySpan(rs time.Duration) SemaphoreOptionHandler {
+	return func(opts *Semaphore) {
+		opts.RetrySpan = rs
+	}
+}
@@ -6,14 +6,17 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/rumis/storage/locker"
 	"github.com/rumis/storage/meta"
 	"github.com/rumis/storage/pkg/ujson"
 	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
 	"github.com/rumis/storage/srepo"
 )
 
+// 错误定义
+var ErrOutNotZero error = serr.New(serr.ErrInvalid, "params out must implements Zero interface")
+
 // OneCacheRepoOptionsHandler 单一对象缓存配置处理方法
 type OneCacheRepoOptionsHandler func(*OneCacheRepoOptions)
 
@@ -110,7 +113,7 @@ func NewOneCacheRepoReader(opts OneCacheRepoOptions) func(ctx context.Context, p
 	return func(ctx context.Context, params interface{}, expire time.Duration, out interface{}) error {
 		zero, ok := out.(meta.Zero)
 		if !ok {
-			return errors.New("params out must implements Zero interface")
+			return ErrOutNotZero
 		}
 		// 读取缓存
 		err := opts.CacheReader(ctx, params, out)
@@ -118,7 +121,7 @@ func NewOneCacheRepoReader(opts OneCacheRepoOptions) func(ctx context.Context, p
 			// 缓存读取成功，直接返回
 			return nil
 		}
-		if err != nil && err != redis.Nil {
+		if !errors.Is(err, serr.ErrNotFound) {
 			// 缓存读取错误
 			fmt.Println("记录错误")
 		}
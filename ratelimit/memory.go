@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// memoryEntry 内存限流状态
+type memoryEntry struct {
+	count    int64       // 固定窗口计数
+	resetAt  time.Time   // 固定窗口结束时间
+	log      []time.Time // 滑动窗口请求时间
+	tokens   float64     // 令牌桶剩余令牌
+	updateAt time.Time   // 令牌桶上次更新时间
+}
+
+// NewMemoryBackend 创建基于内存的限流后端，仅在单进程内生效，一般用于测试
+func NewMemoryBackend() Backend {
+	var mu sync.Mutex
+	entries := make(map[string]*memoryEntry)
+	return func(ctx context.Context, alg Algorithm, key string, limit Limit, n int64, now time.Time) (Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		e, ok := entries[key]
+		if !ok {
+			e = &memoryEntry{}
+			entries[key] = e
+		}
+		switch alg {
+		case AlgorithmFixedWindow:
+			return memoryFixedWindow(e, limit, n, now), nil
+		case AlgorithmSlidingLog:
+			return memorySlidingLog(e, limit, n, now), nil
+		case AlgorithmTokenBucket:
+			return memoryTokenBucket(e, limit, n, now), nil
+		default:
+			return Result{}, ErrAlgorithmUnsupport
+		}
+	}
+}
+
+// memoryFixedWindow 固定窗口
+func memoryFixedWindow(e *memoryEntry, limit Limit, n int64, now time.Time) Result {
+	if !now.Before(e.resetAt) {
+		e.count = 0
+		e.resetAt = now.Add(limit.Period)
+	}
+	reset := e.resetAt.Sub(now)
+	if e.count+n > limit.Rate {
+		return Result{Allowed: false, Remaining: limit.Rate - e.count, ResetAfter: reset, RetryAfter: reset}
+	}
+	e.count += n
+	return Result{Allowed: true, Remaining: limit.Rate - e.count, ResetAfter: reset}
+}
+
+// memorySlidingLog 滑动窗口日志
+func memorySlidingLog(e *memoryEntry, limit Limit, n int64, now time.Time) Result {
+	// 清理窗口外的请求
+	start := now.Add(-limit.Period)
+	i := 0
+	for i < len(e.log) && !e.log[i].After(start) {
+		i++
+	}
+	e.log = e.log[i:]
+	cnt := int64(len(e.log))
+	if cnt+n > limit.Rate {
+		retry := e.log[cnt+n-limit.Rate-1].Add(limit.Period).Sub(now)
+		reset := e.log[cnt-1].Add(limit.Period).Sub(now)
+		return Result{Allowed: false, Remaining: limit.Rate - cnt, ResetAfter: reset, RetryAfter: retry}
+	}
+	for j := int64(0); j < n; j++ {
+		e.log = append(e.log, now)
+	}
+	return Result{Allowed: true, Remaining: limit.Rate - cnt - n, ResetAfter: limit.Period}
+}
+
+// memoryTokenBucket 令牌桶
+func memoryTokenBucket(e *memoryEntry, limit Limit, n int64, now time.Time) Result {
+	burst := float64(limit.capacity(AlgorithmTokenBucket))
+	rate := float64(limit.Rate)
+	period := float64(limit.Period.Milliseconds())
+	if e.updateAt.IsZero() {
+		e.tokens = burst
+		e.updateAt = now
+	}
+	elapsed := float64(now.Sub(e.updateAt).Milliseconds())
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	e.tokens = math.Min(burst, e.tokens+elapsed*rate/period)
+	e.updateAt = now
+
+	res := Result{}
+	if e.tokens >= float64(n) {
+		e.tokens -= float64(n)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((float64(n)-e.tokens)*period/rate)) * time.Millisecond
+	}
+	res.Remaining = int64(math.Floor(e.tokens))
+	res.ResetAfter = time.Duration(math.Ceil((burst-e.tokens)*period/rate)) * time.Millisecond
+	return res
+}
@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/rumis/storage/serr"
+)
+
+// Algorithm 限流算法
+type Algorithm int8
+
+const (
+	AlgorithmFixedWindow Algorithm = 1 // 固定窗口
+	AlgorithmSlidingLog  Algorithm = 2 // 滑动窗口日志
+	AlgorithmTokenBucket Algorithm = 3 // 令牌桶
+)
+
+// DefaultLimit 默认配额 每秒10次
+var DefaultLimit Limit = Limit{Rate: 10, Period: time.Second}
+
+// DefaultRetrySpan Wait默认最小重试间隔 10ms
+var DefaultRetrySpan time.Duration = time.Millisecond * 10
+
+// Limit 限流配额
+//
+//	固定窗口/滑动窗口：Period时间内最多允许Rate次请求
+//	令牌桶：每Period补充Rate个令牌，桶容量为Burst（为0时等于Rate）
+type Limit struct {
+	Rate   int64
+	Period time.Duration
+	Burst  int64
+}
+
+// PerSecond 每秒rate次
+func PerSecond(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+// PerMinute 每分钟rate次
+func PerMinute(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+// capacity 最大可用配额
+func (l Limit) capacity(alg Algorithm) int64 {
+	if alg == AlgorithmTokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Result 限流结果
+type Result struct {
+	Allowed    bool          // 是否放行
+	Remaining  int64         // 剩余配额
+	ResetAfter time.Duration // 配额完全恢复的等待时间
+	RetryAfter time.Duration // 被拒绝时建议的重试等待时间，放行时为0
+}
+
+// Backend 限流存储后端，需保证单次调用的原子性
+// @params n 本次消耗的配额数
+// @params now 当前时间
+type Backend func(ctx context.Context, alg Algorithm, key string, limit Limit, n int64, now time.Time) (Result, error)
+
+// 错误定义
+var ErrBackendNil error = serr.New(serr.ErrInvalid, "rate limit backend is nil")
+var ErrLimitInvalid error = serr.New(serr.ErrInvalid, "rate limit rate must be positive and period must be at least 1ms")
+var ErrCountInvalid error = serr.New(serr.ErrInvalid, "requested count must be positive")
+var ErrExceedCapacity error = serr.New(serr.ErrInvalid, "requested count exceeds the limit capacity")
+var ErrAlgorithmUnsupport error = serr.New(serr.ErrInvalid, "unsupport rate limit algorithm")
+
+// LimiterOptions 限流配置
+type LimiterOptions struct {
+	Algorithm Algorithm
+	Limit     Limit
+	Backend   Backend
+	RetrySpan time.Duration    // Wait时最小重试间隔
+	Clock     func() time.Time // 时间来源
+}
+
+// LimiterOptionHandler 限流配置选项
+type LimiterOptionHandler func(*LimiterOptions)
+
+// DefaultLimiterOptions 创建默认的限流配置
+func DefaultLimiterOptions() LimiterOptions {
+	return LimiterOptions{
+		Algorithm: AlgorithmFixedWindow,
+		Limit:     DefaultLimit,
+		RetrySpan: DefaultRetrySpan,
+		Clock:     time.Now,
+	}
+}
+
+// WithAlgorithm 限流算法
+func WithAlgorithm(alg Algorithm) LimiterOptionHandler {
+	return func(opts *LimiterOptions) {
+		opts.Algorithm = alg
+	}
+}
+
+// WithLimit 限流配额
+func WithLimit(l Limit) LimiterOptionHandler {
+	return func(opts *LimiterOptions) {
+		opts.Limit = l
+	}
+}
+
+// WithBackend 存储后端
+func WithBackend(b Backend) LimiterOptionHandler {
+	return func(opts *LimiterOptions) {
+		opts.Backend = b
+	}
+}
+
+// WithRetrySpan Wait时最小重试间隔
+func WithRetrySpan(span time.Duration) LimiterOptionHandler {
+	return func(opts *LimiterOptions) {
+		opts.RetrySpan = span
+	}
+}
+
+// WithClock 时间来源，一般用于测试
+func WithClock(fn func() time.Time) LimiterOptionHandler {
+	return func(opts *LimiterOptions) {
+		opts.Clock = fn
+	}
+}
+
+// Limiter 限流器
+type Limiter struct {
+	opts LimiterOptions
+}
+
+// NewLimiter 创建新的限流器
+func NewLimiter(hands ...LimiterOptionHandler) Limiter {
+	// 默认配置
+	opts := DefaultLimiterOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return Limiter{opts: opts}
+}
+
+// Allow 消耗一个配额
+func (l Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 消耗n个配额，n必须大于0
+func (l Limiter) AllowN(ctx context.Context, key string, n int64) (Result, error) {
+	if l.opts.Backend == nil {
+		return Result{}, ErrBackendNil
+	}
+	// 令牌桶按毫秒计算补充速率，Period不能小于1毫秒
+	if l.opts.Limit.Rate <= 0 || l.opts.Limit.Period < time.Millisecond {
+		return Result{}, ErrLimitInvalid
+	}
+	if n <= 0 {
+		return Result{}, ErrCountInvalid
+	}
+	if n > l.opts.Limit.capacity(l.opts.Algorithm) {
+		return Result{}, ErrExceedCapacity
+	}
+	return l.opts.Backend(ctx, l.opts.Algorithm, key, l.opts.Limit, n, l.opts.Clock())
+}
+
+// Wait 阻塞直至获取一个配额或者ctx结束
+func (l Limiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN 阻塞直至获取n个配额或者ctx结束
+func (l Limiter) WaitN(ctx context.Context, key string, n int64) error {
+	for {
+		res, err := l.AllowN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+		wait := res.RetryAfter
+		if wait < l.opts.RetrySpan {
+			wait = l.opts.RetrySpan
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/scache"
+	"github.com/rumis/storage/serr"
+)
+
+// testClock 可控时钟
+type testClock struct {
+	now    time.Time
+	server *miniredis.Miniredis
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	if c.server != nil {
+		c.server.FastForward(d)
+	}
+}
+
+func testBackends(t *testing.T) map[string]func() (Backend, *testClock) {
+	return map[string]func() (Backend, *testClock){
+		"memory": func() (Backend, *testClock) {
+			return NewMemoryBackend(), &testClock{now: time.Unix(1600000000, 0)}
+		},
+		"redis": func() (Backend, *testClock) {
+			// 启动内存Redis服务并创建Client
+			server, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rClient := redis.NewClient(&redis.Options{
+				Addr: server.Addr(),
+			})
+			return NewRedisBackend(scache.WithClient(rClient), scache.WithPrefix("test_ratelimit_")), &testClock{now: time.Unix(1600000000, 0), server: server}
+		},
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	ctx := context.TODO()
+	for name, fn := range testBackends(t) {
+		backend, clock := fn()
+		l := NewLimiter(WithBackend(backend), WithAlgorithm(AlgorithmFixedWindow), WithLimit(PerSecond(3)), WithClock(clock.Now))
+		for i := 0; i < 3; i++ {
+			res, err := l.Allow(ctx, "k1")
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if !res.Allowed || res.Remaining != int64(2-i) {
+				t.Fatal(name, i, res)
+			}
+		}
+		res, err := l.Allow(ctx, "k1")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if res.Allowed || res.RetryAfter <= 0 {
+			t.Fatal(name, "fixed window should reject", res)
+		}
+		// 其他KEY不受影响
+		res, _ = l.Allow(ctx, "k2")
+		if !res.Allowed {
+			t.Fatal(name, "k2 should allowed", res)
+		}
+		// 窗口重置
+		clock.Advance(time.Second)
+		res, _ = l.AllowN(ctx, "k1", 3)
+		if !res.Allowed || res.Remaining != 0 {
+			t.Fatal(name, "fixed window should reset", res)
+		}
+	}
+}
+
+func TestSlidingLog(t *testing.T) {
+	ctx := context.TODO()
+	for name, fn := range testBackends(t) {
+		backend, clock := fn()
+		l := NewLimiter(WithBackend(backend), WithAlgorithm(AlgorithmSlidingLog), WithLimit(PerSecond(2)), WithClock(clock.Now))
+		res, _ := l.Allow(ctx, "k1")
+		if !res.Allowed {
+			t.Fatal(name, res)
+		}
+		clock.Advance(time.Millisecond * 600)
+		res, _ = l.Allow(ctx, "k1")
+		if !res.Allowed || res.Remaining != 0 {
+			t.Fatal(name, res)
+		}
+		res, _ = l.Allow(ctx, "k1")
+		if res.Allowed || res.RetryAfter != time.Millisecond*400 {
+			t.Fatal(name, "sliding log should reject", res)
+		}
+		// 第一个请求滑出窗口
+		clock.Advance(time.Millisecond * 400)
+		res, _ = l.Allow(ctx, "k1")
+		if !res.Allowed || res.Remaining != 0 {
+			t.Fatal(name, "sliding log should allow", res)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.TODO()
+	for name, fn := range testBackends(t) {
+		backend, clock := fn()
+		l := NewLimiter(WithBackend(backend), WithAlgorithm(AlgorithmTokenBucket), WithLimit(Limit{Rate: 10, Period: time.Second, Burst: 5}), WithClock(clock.Now))
+		res, _ := l.AllowN(ctx, "k1", 5)
+		if !res.Allowed || res.Remaining != 0 || res.ResetAfter != time.Millisecond*500 {
+			t.Fatal(name, res)
+		}
+		res, _ = l.Allow(ctx, "k1")
+		if res.Allowed || res.RetryAfter != time.Millisecond*100 {
+			t.Fatal(name, "token bucket should reject", res)
+		}
+		// 补充令牌
+		clock.Advance(time.Millisecond * 200)
+		res, _ = l.AllowN(ctx, "k1", 2)
+		if !res.Allowed || res.Remaining != 0 {
+			t.Fatal(name, "token bucket should refill", res)
+		}
+		_, err := l.AllowN(ctx, "k1", 6)
+		if err != ErrExceedCapacity {
+			t.Fatal(name, err)
+		}
+		// 负数配额不能补充令牌
+		_, err = l.AllowN(ctx, "k1", -5)
+		if err != ErrCountInvalid {
+			t.Fatal(name, err)
+		}
+		_, err = l.AllowN(ctx, "k1", 0)
+		if err != ErrCountInvalid {
+			t.Fatal(name, err)
+		}
+		res, _ = l.Allow(ctx, "k1")
+		if res.Allowed {
+			t.Fatal(name, "negative count should not add tokens", res)
+		}
+		// 周期小于1毫秒
+		_, err = NewLimiter(WithBackend(backend), WithAlgorithm(AlgorithmTokenBucket), WithLimit(Limit{Rate: 10, Period: time.Microsecond}), WithClock(clock.Now)).Allow(ctx, "k2")
+		if err != ErrLimitInvalid || !errors.Is(err, serr.ErrInvalid) {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := NewLimiter(WithBackend(NewMemoryBackend()), WithAlgorithm(AlgorithmTokenBucket), WithLimit(Limit{Rate: 100, Period: time.Second, Burst: 1}))
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		err := l.Wait(ctx, "k1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 超时返回
+	l2 := NewLimiter(WithBackend(NewMemoryBackend()), WithLimit(PerMinute(1)))
+	ctx2, cancel2 := context.WithTimeout(context.TODO(), time.Millisecond*50)
+	defer cancel2()
+	l2.Allow(ctx2, "k1")
+	err := l2.Wait(ctx2, "k1")
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
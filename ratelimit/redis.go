@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/scache"
+)
+
+// 各脚本统一返回 {allowed, remaining, reset_after_ms, retry_after_ms}
+
+// fixedWindowScript 固定窗口
+// KEYS[1] 计数KEY
+// ARGV rate, period_ms, n
+var fixedWindowScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	cur = 0
+	ttl = period
+end
+if cur + n > rate then
+	return {0, rate - cur, ttl, ttl}
+end
+cur = redis.call('INCRBY', KEYS[1], n)
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], period)
+end
+return {1, rate - cur, ttl, 0}
+`)
+
+// slidingLogScript 滑动窗口日志
+// KEYS[1] 有序集合KEY，score为请求时间
+// ARGV rate, period_ms, n, now_ms, member
+var slidingLogScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local cnt = redis.call('ZCARD', KEYS[1])
+if cnt + n > rate then
+	local retry = period
+	local oldest = redis.call('ZRANGE', KEYS[1], cnt + n - rate - 1, cnt + n - rate - 1, 'WITHSCORES')
+	if oldest[2] then
+		retry = tonumber(oldest[2]) + period - now
+	end
+	local reset = period
+	local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	if newest[2] then
+		reset = tonumber(newest[2]) + period - now
+	end
+	return {0, rate - cnt, reset, retry}
+end
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], now, ARGV[5] .. ':' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], period)
+return {1, rate - cnt - n, period, 0}
+`)
+
+// tokenBucketScript 令牌桶
+// KEYS[1] 哈希KEY，字段tokens为剩余令牌，ts为上次更新时间
+// ARGV rate, period_ms, burst, n, now_ms
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+local elapsed = now - ts
+if elapsed < 0 then
+	elapsed = 0
+end
+tokens = math.min(burst, tokens + elapsed * rate / period)
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) * period / rate)
+end
+local reset = math.ceil((burst - tokens) * period / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.max(reset, 1))
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// NewRedisBackend 创建基于Redis的限流后端，各算法均通过Lua脚本保证原子性
+// 支持scache的Client、Prefix、ExecLogger配置
+func NewRedisBackend(hands ...scache.RedisOptionHandler) Backend {
+	// 默认配置
+	opts := scache.DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, alg Algorithm, key string, limit Limit, n int64, now time.Time) (Result, error) {
+		startTime := time.Now()
+		if opts.Client == nil {
+			return Result{}, scache.ExecLogError(ctx, opts.ExecLogFn, startTime, key, scache.ErrClientNil)
+		}
+		key = opts.Prefix + key
+		period := limit.Period.Milliseconds()
+		var cmd *redis.Cmd
+		switch alg {
+		case AlgorithmFixedWindow:
+			cmd = fixedWindowScript.Run(ctx, opts.Client, []string{key}, limit.Rate, period, n)
+		case AlgorithmSlidingLog:
+			member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+			cmd = slidingLogScript.Run(ctx, opts.Client, []string{key}, limit.Rate, period, n, now.UnixNano()/int64(time.Millisecond), member)
+		case AlgorithmTokenBucket:
+			cmd = tokenBucketScript.Run(ctx, opts.Client, []string{key}, limit.Rate, period, limit.capacity(alg), n, now.UnixNano()/int64(time.Millisecond))
+		default:
+			return Result{}, scache.ExecLogError(ctx, opts.ExecLogFn, startTime, key, ErrAlgorithmUnsupport)
+		}
+		vals, err := cmd.Int64Slice()
+		if err != nil {
+			return Result{}, scache.ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+		}
+		if len(vals) != 4 {
+			return Result{}, scache.ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), fmt.Errorf("unexpected rate limit script result %v", vals))
+		}
+		scache.ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return Result{
+			Allowed:    vals[0] == 1,
+			Remaining:  vals[1],
+			ResetAfter: time.Duration(vals[2]) * time.Millisecond,
+			RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+		}, nil
+	}
+}
@@ -338,3 +338,121 @@ func NewReaderSetNX(hands ...RedisOptionHandler) RedisKeyValueNX {
 		}
 	}
 }
+
+// fencingNXScript 加锁成功后递增fencing计数器，计数器不过期以保证token单调递增
+var fencingNXScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// DefaultFencingSuffix fencing计数器KEY后缀
+var DefaultFencingSuffix = "_fencing"
+
+// NewReaderFencingSetNX 创建带fencing token的SetNX
+// 锁KEY为【prefix+p.Key】，计数器KEY为【prefix+p.Key+DefaultFencingSuffix】
+func NewReaderFencingSetNX(hands ...RedisOptionHandler) RedisKeyValueFencingNX {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, params Pair, expiration time.Duration) (int64, bool) {
+		startTime := time.Now()
+		if opts.Client == nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrClientNil)
+			return 0, false
+		}
+		if opts.Prefix == "" {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrPrefixNil)
+			return 0, false
+		}
+		key := opts.Prefix + params.Key
+		cmd := fencingNXScript.Run(ctx, opts.Client, []string{key, key + DefaultFencingSuffix}, params.Value, expiration.Milliseconds())
+		token, err := cmd.Int64()
+		if err != nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+			return 0, false
+		}
+		ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return token, token > 0
+	}
+}
+
+// compareSetScript 当前值与ARGV[1]相同时写入ARGV[2]，过期时间ARGV[3]毫秒
+var compareSetScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// compareDelScript 当前值与ARGV[1]相同时删除
+var compareDelScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// NewRedisKeyValueCompareWriter 创建比较后写入，KEY为【prefix+p.Key】，值为p.Value
+// 用于只允许持有旧值的一方覆盖，如处理中记录被他人接管后不再写入
+func NewRedisKeyValueCompareWriter(hands ...RedisOptionHandler) RedisKeyValueCompareWriter {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, params Pair, old string, expiration time.Duration) bool {
+		startTime := time.Now()
+		if opts.Client == nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrClientNil)
+			return false
+		}
+		if opts.Prefix == "" {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrPrefixNil)
+			return false
+		}
+		cmd := compareSetScript.Run(ctx, opts.Client, []string{opts.Prefix + params.Key}, old, params.Value, expiration.Milliseconds())
+		n, err := cmd.Int64()
+		if err != nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+			return false
+		}
+		ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return n == 1
+	}
+}
+
+// NewRedisKeyValueCompareDeleter 创建比较后删除，KEY为【prefix+p.Key】的值等于p.Value时删除
+func NewRedisKeyValueCompareDeleter(hands ...RedisOptionHandler) RedisKeyValueCompareDeleter {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, params Pair) bool {
+		startTime := time.Now()
+		if opts.Client == nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrClientNil)
+			return false
+		}
+		if opts.Prefix == "" {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, params, ErrPrefixNil)
+			return false
+		}
+		cmd := compareDelScript.Run(ctx, opts.Client, []string{opts.Prefix + params.Key}, params.Value)
+		n, err := cmd.Int64()
+		if err != nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+			return false
+		}
+		ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return n == 1
+	}
+}
@@ -15,6 +15,7 @@ func DefaultRedisLocker(client *redis.Client, biz string) locker.Locker {
 	prefix := defaultLockerPrefix + biz + "_"
 	return locker.NewLocker(
 		locker.WithLockerAdder(RedisLockerAdder(NewReaderSetNX(WithClient(client), WithPrefix(prefix)), locker.DefaultExpire)),
+		locker.WithLockerFencingAdder(RedisLockerFencingAdder(NewReaderFencingSetNX(WithClient(client), WithPrefix(prefix)), locker.DefaultExpire)),
 		locker.WithLockerDeleter(RedisLockerDeleter(NewRedisKeyValueDeleter(WithClient(client), WithPrefix(prefix)))),
 	)
 }
@@ -27,6 +28,13 @@ func RedisLockerAdder(a RedisKeyValueNX, expire time.Duration) locker.LockerAdde
 	}
 }
 
+// RedisLockerFencingAdder 加锁并返回fencing token
+func RedisLockerFencingAdder(a RedisKeyValueFencingNX, expire time.Duration) locker.LockerFencingAdder {
+	return func(ctx context.Context, key string) (int64, bool) {
+		return a(ctx, Pair{Key: key, Value: key}, expire)
+	}
+}
+
 // RedisLockerDeleter
 func RedisLockerDeleter(d RedisKeyValueDeleter) locker.LockerDeleter {
 	return func(ctx context.Context, key string) error {
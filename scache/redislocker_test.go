@@ -0,0 +1,51 @@
+package scache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisFencingLocker(t *testing.T) {
+
+	ctx := context.TODO()
+
+	// 启动内存Redis服务并创建Client
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+
+	l := DefaultRedisLocker(rClient, "fencing")
+
+	// 首次加锁
+	t1, ok := l.FencingAdder(ctx, "k1")
+	if !ok || t1 != 1 {
+		t.Fatal("fencing lock error", t1, ok)
+	}
+	// 锁未释放，再次加锁失败
+	_, ok = l.FencingAdder(ctx, "k1")
+	if ok {
+		t.Fatal("fencing lock should fail")
+	}
+	// 释放后重新加锁，token递增
+	err = l.Deleter(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, ok := l.FencingAdder(ctx, "k1")
+	if !ok || t2 <= t1 {
+		t.Fatal("fencing token must increase", t1, t2)
+	}
+	// 锁过期后重新加锁，token递增
+	server.FastForward(l.Expire * 2)
+	t3, ok := l.FencingAdder(ctx, "k1")
+	if !ok || t3 <= t2 {
+		t.Fatal("fencing token must increase", t2, t3)
+	}
+}
@@ -0,0 +1,134 @@
+package scache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/locker"
+)
+
+var defaultSemaphorePrefix = "tal_jiaoyan_storage_semaphore_"
+
+// 信号量使用有序集合保存持有者，score为持有者的过期时间(ms)，时间以Redis服务端为准
+// 集合的TTL取最晚过期的持有者，且只延长不缩短，避免短租约的持有者提前清掉整个集合
+
+// semaphoreAcquireScript 清理过期持有者后尝试获取
+// KEYS[1] 有序集合KEY
+// ARGV holder, limit, expire_ms
+var semaphoreAcquireScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expire = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], now + expire, ARGV[1])
+	local top = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	local ttl = tonumber(top[2]) - now
+	if redis.call('PTTL', KEYS[1]) < ttl then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+	return 1
+end
+return 0
+`)
+
+// semaphoreRefreshScript 持有者未过期时续期
+// KEYS[1] 有序集合KEY
+// ARGV holder, expire_ms
+var semaphoreRefreshScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expire = tonumber(ARGV[2])
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) > now then
+	redis.call('ZADD', KEYS[1], now + expire, ARGV[1])
+	local top = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	local ttl = tonumber(top[2]) - now
+	if redis.call('PTTL', KEYS[1]) < ttl then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+	return 1
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+return 0
+`)
+
+// DefaultRedisSemaphore 创建基于Redis的分布式信号量
+func DefaultRedisSemaphore(client *redis.Client, biz string, limit int64) locker.Semaphore {
+	prefix := defaultSemaphorePrefix + biz + "_"
+	return locker.NewSemaphore(
+		locker.WithSemaphoreAcquirer(NewRedisSemaphoreAcquirer(WithClient(client), WithPrefix(prefix))),
+		locker.WithSemaphoreReleaser(NewRedisSemaphoreReleaser(WithClient(client), WithPrefix(prefix))),
+		locker.WithSemaphoreRefresher(NewRedisSemaphoreRefresher(WithClient(client), WithPrefix(prefix))),
+		locker.WithSemaphoreLimit(limit),
+	)
+}
+
+// NewRedisSemaphoreAcquirer 信号量获取
+func NewRedisSemaphoreAcquirer(hands ...RedisOptionHandler) locker.SemaphoreAcquirer {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, key string, holder string, limit int64, expire time.Duration) bool {
+		startTime := time.Now()
+		if opts.Client == nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, key, ErrClientNil)
+			return false
+		}
+		cmd := semaphoreAcquireScript.Run(ctx, opts.Client, []string{opts.Prefix + key}, holder, limit, expire.Milliseconds())
+		res, err := cmd.Int64()
+		if err != nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+			return false
+		}
+		ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return res == 1
+	}
+}
+
+// NewRedisSemaphoreReleaser 信号量释放
+func NewRedisSemaphoreReleaser(hands ...RedisOptionHandler) locker.SemaphoreReleaser {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, key string, holder string) error {
+		startTime := time.Now()
+		if opts.Client == nil {
+			return ExecLogError(ctx, opts.ExecLogFn, startTime, key, ErrClientNil)
+		}
+		cmd := opts.Client.ZRem(ctx, opts.Prefix+key, holder)
+		return ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), cmd.Err())
+	}
+}
+
+// NewRedisSemaphoreRefresher 信号量续期
+func NewRedisSemaphoreRefresher(hands ...RedisOptionHandler) locker.SemaphoreRefresher {
+	// 默认配置
+	opts := DefaultRedisOptions()
+	// 自定义配置设置
+	for _, hand := range hands {
+		hand(&opts)
+	}
+	return func(ctx context.Context, key string, holder string, expire time.Duration) bool {
+		startTime := time.Now()
+		if opts.Client == nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, key, ErrClientNil)
+			return false
+		}
+		cmd := semaphoreRefreshScript.Run(ctx, opts.Client, []string{opts.Prefix + key}, holder, expire.Milliseconds())
+		res, err := cmd.Int64()
+		if err != nil {
+			ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), err)
+			return false
+		}
+		ExecLogError(ctx, opts.ExecLogFn, startTime, cmd.String(), nil)
+		return res == 1
+	}
+}
@@ -0,0 +1,119 @@
+package scache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/rumis/storage/locker"
+)
+
+func TestRedisSemaphore(t *testing.T) {
+
+	ctx := context.TODO()
+
+	// 启动内存Redis服务并创建Client
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+	now := time.Now()
+	server.SetTime(now)
+
+	s := DefaultRedisSemaphore(rClient, "export", 2)
+	s = locker.NewSemaphore(
+		locker.WithSemaphoreAcquirer(s.Acquirer),
+		locker.WithSemaphoreReleaser(s.Releaser),
+		locker.WithSemaphoreRefresher(s.Refresher),
+		locker.WithSemaphoreLimit(2),
+		locker.WithSemaphoreExpire(time.Second),
+		locker.WithSemaphoreRetryTimes(0),
+	)
+
+	if !s.Acquire(ctx, "tenant1", "h1") || !s.Acquire(ctx, "tenant1", "h2") {
+		t.Fatal("acquire error")
+	}
+	// 超过并发数
+	if s.Acquire(ctx, "tenant1", "h3") {
+		t.Fatal("acquire should fail")
+	}
+	// 重复获取视为续期
+	if !s.Acquire(ctx, "tenant1", "h1") {
+		t.Fatal("reacquire error")
+	}
+	// 其他key不受影响
+	if !s.Acquire(ctx, "tenant2", "h3") {
+		t.Fatal("acquire tenant2 error")
+	}
+	// 释放后可获取
+	err = s.Release(ctx, "tenant1", "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Acquire(ctx, "tenant1", "h3") {
+		t.Fatal("acquire after release error")
+	}
+
+	// h1续期，h3未续期过期后被清理
+	server.SetTime(now.Add(time.Millisecond * 800))
+	if !s.Refresh(ctx, "tenant1", "h1") {
+		t.Fatal("refresh error")
+	}
+	server.SetTime(now.Add(time.Millisecond * 1500))
+	if s.Refresh(ctx, "tenant1", "h3") {
+		t.Fatal("refresh expired holder should fail")
+	}
+	if !s.Acquire(ctx, "tenant1", "h4") {
+		t.Fatal("dead holder should be cleaned")
+	}
+	if s.Acquire(ctx, "tenant1", "h5") {
+		t.Fatal("acquire should fail")
+	}
+}
+
+func TestRedisSemaphoreTTL(t *testing.T) {
+
+	ctx := context.TODO()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rClient := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+	now := time.Now()
+	server.SetTime(now)
+
+	acquire := NewRedisSemaphoreAcquirer(WithClient(rClient))
+	refresh := NewRedisSemaphoreRefresher(WithClient(rClient))
+
+	// 短租约的持有者不能缩短集合的TTL
+	if !acquire(ctx, "ttl", "long", 2, time.Minute) || !acquire(ctx, "ttl", "short", 2, time.Second) {
+		t.Fatal("acquire error")
+	}
+	if ttl := server.TTL("ttl"); ttl < time.Second*59 {
+		t.Fatal("ttl shortened:", ttl)
+	}
+	if !refresh(ctx, "ttl", "short", time.Second) {
+		t.Fatal("refresh error")
+	}
+	if ttl := server.TTL("ttl"); ttl < time.Second*59 {
+		t.Fatal("ttl shortened after refresh:", ttl)
+	}
+
+	// 短租约过期后长租约仍然有效
+	server.FastForward(time.Second * 2)
+	server.SetTime(now.Add(time.Second * 2))
+	if !server.Exists("ttl") {
+		t.Fatal("key expired with long holder alive")
+	}
+	if !refresh(ctx, "ttl", "long", time.Minute) {
+		t.Fatal("refresh long error")
+	}
+}
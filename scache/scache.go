@@ -2,11 +2,11 @@ package scache
 
 import (
 	"context"
-	"errors"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/serr"
 )
 
 // Pair 键值对
@@ -27,6 +27,15 @@ type RedisKeyValueWriter func(ctx context.Context, params interface{}, expire ti
 // RedisKeyValueNX Redis SetNX
 type RedisKeyValueNX func(ctx context.Context, params interface{}, expire time.Duration) bool
 
+// RedisKeyValueFencingNX Redis SetNX，成功时对计数KEY执行INCR并返回fencing token
+type RedisKeyValueFencingNX func(ctx context.Context, params Pair, expire time.Duration) (int64, bool)
+
+// RedisKeyValueCompareWriter 当前值等于old时写入新值，返回是否写入
+type RedisKeyValueCompareWriter func(ctx context.Context, params Pair, old string, expire time.Duration) bool
+
+// RedisKeyValueCompareDeleter 当前值等于old时删除，返回是否删除
+type RedisKeyValueCompareDeleter func(ctx context.Context, params Pair) bool
+
 // Redis K-V类型删除
 //
 // 参数param支持以下4种类型:
@@ -58,12 +67,12 @@ type RedisListStringReader func(context.Context) (string, error)
 // RedisListObjectReader Redis List类型读取，每次读取一个值，返回结果为对象
 type RedisListObjectReader func(context.Context, interface{}) error
 
-// ExecLogError 记录调用日志
+// ExecLogError 记录调用日志，返回按serr分类的错误，如redis.Nil包装为serr.ErrNotFound
 func ExecLogError(ctx context.Context, fn meta.RedisExecLogFunc, stime time.Time, args interface{}, e error) error {
 	if fn != nil {
 		fn(ctx, time.Since(stime), args, e)
 	}
-	return e
+	return serr.From(e)
 }
 
 // 选项
@@ -111,8 +120,8 @@ func WithExecLogger(fn meta.RedisExecLogFunc) RedisOptionHandler {
 }
 
 // Redis客户端空
-var ErrClientNil error = errors.New("redis client is nil")
-var ErrKeyFnNil error = errors.New("key generater is nil")
-var ErrPrefixNil error = errors.New("key prefix is nil")
-var ErrKeyGenerate error = errors.New("key generate error")
-var ErrKeyFormat error = errors.New("key format error")
+var ErrClientNil error = serr.New(serr.ErrInvalid, "redis client is nil")
+var ErrKeyFnNil error = serr.New(serr.ErrInvalid, "key generater is nil")
+var ErrPrefixNil error = serr.New(serr.ErrInvalid, "key prefix is nil")
+var ErrKeyGenerate error = serr.New(serr.ErrInvalid, "key generate error")
+var ErrKeyFormat error = serr.New(serr.ErrInvalid, "key format error")
@@ -0,0 +1,180 @@
+package serr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"reflect"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 错误分类，通过errors.Is判断
+var ErrNotFound error = errors.New("not found")
+var ErrDuplicate error = errors.New("duplicate")
+var ErrConflict error = errors.New("conflict")
+var ErrTimeout error = errors.New("timeout")
+var ErrUnavailable error = errors.New("unavailable")
+var ErrInvalid error = errors.New("invalid")
+
+// Error 分类错误，errors.Is可同时匹配分类及原始错误，错误信息为原始错误的信息
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// New 创建分类错误，用于定义哨兵错误
+func New(kind error, text string) error {
+	return &Error{Kind: kind, Err: errors.New(text)}
+}
+
+// Wrap 将err包装为kind分类，err为nil时返回nil
+func Wrap(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// From 根据原始错误确定分类并包装，已分类或无法分类时原样返回
+func From(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	kind := classify(err)
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// Kind 获取错误分类，无法分类时返回nil
+func Kind(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return classify(err)
+}
+
+// MysqlCode 获取MySQL错误码，兼容go-sql-driver/mysql的*MySQLError，不依赖驱动包
+func MysqlCode(err error) (uint16, bool) {
+	f, ok := errorField(err, "Number", reflect.Uint16)
+	if !ok {
+		return 0, false
+	}
+	return uint16(f.Uint()), true
+}
+
+// MySQL错误码分类
+var mysqlKinds = map[uint16]error{
+	1062: ErrDuplicate,   // ER_DUP_ENTRY
+	1586: ErrDuplicate,   // ER_DUP_ENTRY_WITH_KEY_NAME
+	1205: ErrConflict,    // ER_LOCK_WAIT_TIMEOUT
+	1213: ErrConflict,    // ER_LOCK_DEADLOCK
+	3024: ErrTimeout,     // ER_QUERY_TIMEOUT
+	1040: ErrUnavailable, // ER_CON_COUNT_ERROR
+	1053: ErrUnavailable, // ER_SERVER_SHUTDOWN
+	1290: ErrUnavailable, // ER_OPTION_PREVENTS_STATEMENT，如只读
+	2002: ErrUnavailable, // CR_CONNECTION_ERROR
+	2003: ErrUnavailable, // CR_CONN_HOST_ERROR
+	2006: ErrUnavailable, // CR_SERVER_GONE_ERROR
+	2013: ErrUnavailable, // CR_SERVER_LOST
+}
+
+// PostgreSQL SQLSTATE分类，未列出的08开头的状态均为连接错误
+var postgresKinds = map[string]error{
+	"23505": ErrDuplicate,   // unique_violation
+	"40001": ErrConflict,    // serialization_failure
+	"40P01": ErrConflict,    // deadlock_detected
+	"55P03": ErrConflict,    // lock_not_available
+	"57014": ErrTimeout,     // query_canceled，statement_timeout
+	"53300": ErrUnavailable, // too_many_connections
+	"57P01": ErrUnavailable, // admin_shutdown
+}
+
+// SQLite扩展错误码分类
+var sqliteKinds = map[int64]error{
+	1555: ErrDuplicate, // SQLITE_CONSTRAINT_PRIMARYKEY
+	2067: ErrDuplicate, // SQLITE_CONSTRAINT_UNIQUE
+	5:    ErrConflict,  // SQLITE_BUSY
+	6:    ErrConflict,  // SQLITE_LOCKED
+}
+
+// classify 原始错误分类
+func classify(err error) error {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return nil
+	case errors.Is(err, redis.Nil), errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, redis.TxFailedErr):
+		return ErrConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return ErrUnavailable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrTimeout
+		}
+		return ErrUnavailable
+	}
+	if code, ok := MysqlCode(err); ok {
+		return mysqlKinds[code]
+	}
+	if f, ok := errorField(err, "Code", reflect.String); ok {
+		state := f.String()
+		if kind, ok := postgresKinds[state]; ok {
+			return kind
+		}
+		if len(state) == 5 && state[:2] == "08" {
+			return ErrUnavailable
+		}
+		return nil
+	}
+	if f, ok := errorField(err, "ExtendedCode", reflect.Int); ok {
+		if kind, ok := sqliteKinds[f.Int()]; ok {
+			return kind
+		}
+		if f, ok = errorField(err, "Code", reflect.Int); ok {
+			return sqliteKinds[f.Int()]
+		}
+	}
+	return nil
+}
+
+// errorField 在错误链中查找驱动错误结构体的字段
+func errorField(err error, name string, kind reflect.Kind) (reflect.Value, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.Indirect(reflect.ValueOf(err))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Kind() == kind {
+			return f, true
+		}
+	}
+	return reflect.Value{}, false
+}
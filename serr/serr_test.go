@@ -0,0 +1,100 @@
+package serr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type testMysqlError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *testMysqlError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
+type testPostgresError struct {
+	Code    string
+	Message string
+}
+
+func (e *testPostgresError) Error() string {
+	return e.Message
+}
+
+type testSqliteError struct {
+	Code         int
+	ExtendedCode int
+}
+
+func (e testSqliteError) Error() string {
+	return fmt.Sprintf("sqlite error %d", e.ExtendedCode)
+}
+
+type testNetError struct {
+	timeout bool
+}
+
+func (e testNetError) Error() string   { return "i/o error" }
+func (e testNetError) Timeout() bool   { return e.timeout }
+func (e testNetError) Temporary() bool { return false }
+
+func TestFrom(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{redis.Nil, ErrNotFound},
+		{fmt.Errorf("read: %w", sql.ErrNoRows), ErrNotFound},
+		{redis.TxFailedErr, ErrConflict},
+		{context.DeadlineExceeded, ErrTimeout},
+		{context.Canceled, nil},
+		{driver.ErrBadConn, ErrUnavailable},
+		{testNetError{timeout: true}, ErrTimeout},
+		{testNetError{}, ErrUnavailable},
+		{&testMysqlError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, ErrDuplicate},
+		{&testMysqlError{Number: 1213, Message: "Deadlock found"}, ErrConflict},
+		{&testMysqlError{Number: 2006, Message: "MySQL server has gone away"}, ErrUnavailable},
+		{&testMysqlError{Number: 1064, Message: "syntax error"}, nil},
+		{&testPostgresError{Code: "23505"}, ErrDuplicate},
+		{&testPostgresError{Code: "08006"}, ErrUnavailable},
+		{testSqliteError{Code: 19, ExtendedCode: 2067}, ErrDuplicate},
+		{testSqliteError{Code: 5, ExtendedCode: 5}, ErrConflict},
+		{errors.New("unknown"), nil},
+	}
+	for _, c := range cases {
+		if k := Kind(c.err); k != c.kind {
+			t.Fatal(c.err, k)
+		}
+		err := From(c.err)
+		if !errors.Is(err, c.err) || err.Error() != c.err.Error() {
+			t.Fatal(c.err, err)
+		}
+		if c.kind != nil && !errors.Is(err, c.kind) {
+			t.Fatal(c.err, err)
+		}
+		if c.kind == nil && err != c.err {
+			t.Fatal(c.err, err)
+		}
+	}
+	if From(nil) != nil || Wrap(ErrNotFound, nil) != nil {
+		t.Fatal("nil error should not be wrapped")
+	}
+
+	// 哨兵错误保持原值，可同时按分类判断
+	errLocked := New(ErrConflict, "locked")
+	if From(errLocked) != errLocked || !errors.Is(fmt.Errorf("run: %w", errLocked), ErrConflict) || errors.Is(errLocked, ErrNotFound) {
+		t.Fatal(errLocked)
+	}
+	code, ok := MysqlCode(fmt.Errorf("exec: %w", &testMysqlError{Number: 1205}))
+	if !ok || code != 1205 {
+		t.Fatal(code, ok)
+	}
+}
@@ -0,0 +1,200 @@
+package srepo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/expr"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrBatchKeyNil error = serr.New(serr.ErrInvalid, "batch update key column is nil")
+var ErrBatchKeyMissing error = serr.New(serr.ErrInvalid, "batch update key is missing in row")
+var ErrBatchColumnsNil error = serr.New(serr.ErrInvalid, "batch update columns is nil")
+var ErrBatchVersionUnsupported error = serr.New(serr.ErrInvalid, "batch update does not support version column")
+
+// NewSealMysqlBatchUpdater 创建新的Seal批量更新对象，每行数据更新为不同的值
+// data为结构体或map切片，以WithBatchKey指定的字段关联行，生成
+// 【UPDATE t SET c1=CASE id WHEN ? THEN ? ... ELSE c1 END WHERE id IN (...)】，
+// 按WithBulkChunkSize分批执行，返回影响行数之和，where为附加条件
+// 配置WithColumns时仅更新其中的字段；WithFencing附加token条件，持有过期token的行不更新；
+// WithSoftDelete时不更新已删除的数据；乐观锁需逐行判断版本号，配置版本号字段时返回ErrBatchVersionUnsupported
+func NewSealMysqlBatchUpdater(hands ...RepoSealOptionHandler) RepoUpdater {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) (int64, error) {
+		if opts.Err != nil {
+			return 0, opts.Err
+		}
+		if len(opts.Joins) > 0 {
+			return 0, ErrJoinUnsupported
+		}
+		if opts.BatchKey == "" {
+			return 0, ErrBatchKeyNil
+		}
+		if opts.VersionColumn != "" {
+			return 0, ErrBatchVersionUnsupported
+		}
+		rows, err := sealRowMaps(data)
+		if err != nil {
+			return 0, err
+		}
+		size := opts.BulkChunkSize
+		if size <= 0 {
+			size = DefaultBulkChunkSize
+		}
+		var affectCnt int64
+		err = opts.sealBulkTx(ctx, BulkTxAll, func(ctx context.Context) error {
+			for start := 0; start < len(rows); start += size {
+				end := start + size
+				if end > len(rows) {
+					end = len(rows)
+				}
+				cnt, err := opts.sealBatchUpdate(ctx, rows[start:end], handler)
+				if err != nil {
+					return err
+				}
+				affectCnt += cnt
+				if opts.BulkProgress != nil {
+					opts.BulkProgress(int64(end))
+				}
+			}
+			return nil
+		})
+		return affectCnt, err
+	}
+}
+
+// sealBatchUpdate 一条语句更新一批数据
+func (opts RepoSealOptions) sealBatchUpdate(ctx context.Context, rows []map[string]interface{}, handler []ClauseHandler) (int64, error) {
+	keys := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		key, ok := row[opts.BatchKey]
+		if !ok {
+			return 0, ErrBatchKeyMissing
+		}
+		keys = append(keys, key)
+	}
+	where := append([]ClauseHandler{SealIn(opts.BatchKey, keys...)}, handler...)
+	var affectCnt int64
+	err := opts.sealBulkTx(ctx, BulkTxChunk, func(ctx context.Context) error {
+		var err error
+		affectCnt, err = opts.sealRun(ctx, sealOpWrite, HookOpUpdate, true, rows, where, func(ctx context.Context, opts RepoSealOptions, sq query.Query, data interface{}) (int64, error) {
+			// before钩子可能修改数据
+			rows, err := sealRowMaps(data)
+			if err != nil {
+				return 0, err
+			}
+			set := batchSetExp{key: opts.BatchKey, cols: sealBatchColumns(opts, rows), rows: rows, fence: opts.FenceColumn, token: opts.FenceToken}
+			if len(set.cols) == 0 {
+				return 0, ErrBatchColumnsNil
+			}
+			q := sq.Update(opts.Name)
+			for _, v := range where {
+				err := v(q)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if opts.FenceColumn != "" {
+				q.Where(seal.Op(opts.FenceColumn, "<=", opts.FenceToken))
+			}
+			if opts.SoftDeleteColumn != "" {
+				q.Where(sealNotDeleted(opts.SoftDeleteColumn))
+			}
+			var affectCnt int64
+			// 全部字段合并为一个表达式，保证SET子句的顺序
+			err = q.Value(map[string]interface{}{set.cols[0]: set}).Exec(ctx, &affectCnt)
+			return affectCnt, err
+		})
+		return err
+	})
+	return affectCnt, err
+}
+
+// sealBatchColumns 需要更新的字段，除主键及fencing token外全部行字段的并集，配置WithColumns时仅保留其中的字段
+func sealBatchColumns(opts RepoSealOptions, rows []map[string]interface{}) []string {
+	seen := map[string]bool{opts.BatchKey: true, opts.FenceColumn: true}
+	var allowed map[string]bool
+	if len(opts.Columns) > 0 {
+		allowed = make(map[string]bool, len(opts.Columns))
+		for _, c := range opts.Columns {
+			allowed[c] = true
+		}
+	}
+	var cols []string
+	for _, row := range rows {
+		for c := range row {
+			if !seen[c] && (allowed == nil || allowed[c]) {
+				seen[c] = true
+				cols = append(cols, c)
+			}
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// batchSetExp 批量更新的SET表达式，作为第一个字段的值，构建为
+// 【CASE key WHEN ? THEN ? ... ELSE c1 END, c2=CASE key ... END, fence=?】
+type batchSetExp struct {
+	key   string
+	cols  []string
+	rows  []map[string]interface{}
+	fence string
+	token int64
+}
+
+// Build 构建表达式，行中没有的字段保持原值
+func (e batchSetExp) Build(params expr.Params) string {
+	param := func(v interface{}) string {
+		p := fmt.Sprintf("p%v", len(params))
+		params[p] = v
+		return "{:" + p + "}"
+	}
+	sets := make([]string, 0, len(e.cols))
+	for i, c := range e.cols {
+		var b strings.Builder
+		if i > 0 {
+			b.WriteString(c + "=")
+		}
+		b.WriteString("CASE " + e.key)
+		for _, row := range e.rows {
+			v, ok := row[c]
+			if !ok {
+				continue
+			}
+			b.WriteString(" WHEN " + param(row[e.key]) + " THEN " + param(v))
+		}
+		b.WriteString(" ELSE " + c + " END")
+		sets = append(sets, b.String())
+	}
+	if e.fence != "" {
+		sets = append(sets, e.fence+"="+param(e.token))
+	}
+	return strings.Join(sets, ", ")
+}
+
+// sealRowMaps 将多行数据转换为map切片，map类型会复制一份，避免修改调用方数据
+func sealRowMaps(data interface{}) ([]map[string]interface{}, error) {
+	ms, ok := data.([]map[string]interface{})
+	if !ok {
+		return utils.Struct2MapSlice(data)
+	}
+	rows := make([]map[string]interface{}, 0, len(ms))
+	for _, m := range ms {
+		row, _ := sealUpdateMap(m)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,244 @@
+package srepo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrBulkRowType error = serr.New(serr.ErrInvalid, "bulk rows should have the same non-nil type")
+var ErrBulkDataType error = serr.New(serr.ErrInvalid, "bulk data should be a slice")
+
+// DefaultBulkChunkSize 批量写入默认每条语句的行数
+const DefaultBulkChunkSize = 1000
+
+// BulkTxMode 批量写入的事务模式
+type BulkTxMode int8
+
+const (
+	BulkTxNone  BulkTxMode = 0 // 不开启事务，每条语句单独提交
+	BulkTxChunk BulkTxMode = 1 // 每批数据一个事务
+	BulkTxAll   BulkTxMode = 2 // 全部数据一个事务，并发数固定为1
+)
+
+// BulkResult 批量写入结果
+type BulkResult struct {
+	Rows   int64 // 写入的行数
+	Chunks int64 // 执行的语句数
+	LastId int64 // 最后一批数据的最后一个自增ID
+}
+
+// RowIterator 行迭代器，ok为false时表示没有更多数据
+// 同一次写入的行类型需一致，如map[string]interface{}或同一结构体
+type RowIterator func() (row interface{}, ok bool, err error)
+
+// RepoBulkInserter 批量写入，按WithBulkChunkSize分批，数据通过迭代器逐行读取，无需一次性加载到内存
+type RepoBulkInserter func(ctx context.Context, rows RowIterator) (BulkResult, error)
+
+// SliceRows 切片迭代器
+func SliceRows(data interface{}) RowIterator {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	i := 0
+	return func() (interface{}, bool, error) {
+		if v.Kind() != reflect.Slice {
+			return nil, false, ErrBulkDataType
+		}
+		if i >= v.Len() {
+			return nil, false, nil
+		}
+		i++
+		return v.Index(i - 1).Interface(), true, nil
+	}
+}
+
+// ChanRows 通道迭代器，通道关闭时结束，ctx取消时返回错误
+func ChanRows(ctx context.Context, ch <-chan interface{}) RowIterator {
+	return func() (interface{}, bool, error) {
+		select {
+		case row, ok := <-ch:
+			return row, ok, nil
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// NewSealMysqlBulkInserter 创建新的Seal批量写入对象
+// 每批数据一条INSERT语句，未配置WithBulkChunkSize时每批DefaultBulkChunkSize行，
+// 任一批失败时停止读取并返回第一个错误，BulkTxNone及BulkTxChunk模式下已写入的批次不回滚
+func NewSealMysqlBulkInserter(hands ...RepoSealOptionHandler) RepoBulkInserter {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, rows RowIterator) (BulkResult, error) {
+		return opts.sealBulk(ctx, rows)
+	}
+}
+
+// sealBulk 执行批量写入
+func (opts RepoSealOptions) sealBulk(ctx context.Context, rows RowIterator) (BulkResult, error) {
+	size := opts.BulkChunkSize
+	if size <= 0 {
+		size = DefaultBulkChunkSize
+	}
+	parallel := opts.BulkParallel
+	_, inTx := TxFromContext(ctx)
+	if parallel <= 0 || inTx || opts.TX != nil {
+		// 事务只能在一个连接上顺序执行
+		parallel = 1
+	}
+	if opts.BulkTx == BulkTxAll {
+		parallel = 1
+	}
+	var res BulkResult
+	err := opts.sealBulkTx(ctx, BulkTxAll, func(ctx context.Context) error {
+		var err error
+		res, err = opts.sealBulkRun(ctx, rows, size, parallel)
+		return err
+	})
+	return res, err
+}
+
+// bulkChunk 一批数据
+type bulkChunk struct {
+	idx  int64
+	rows reflect.Value
+}
+
+// sealBulkRun 读取数据并分批写入，parallel个协程并发执行
+func (opts RepoSealOptions) sealBulkRun(ctx context.Context, rows RowIterator, size int, parallel int) (BulkResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var res BulkResult
+	var firstErr error
+	var mu sync.Mutex
+	lastIdx := int64(-1)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	chunks := make(chan bulkChunk)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				if ctx.Err() != nil {
+					continue
+				}
+				lastId, err := opts.sealBulkChunk(ctx, c.rows.Interface())
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				res.Rows += int64(c.rows.Len())
+				res.Chunks++
+				if c.idx > lastIdx {
+					lastIdx = c.idx
+					res.LastId = lastId
+				}
+				if opts.BulkProgress != nil {
+					opts.BulkProgress(res.Rows)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	send := func(c bulkChunk) bool {
+		select {
+		case chunks <- c:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	var buf reflect.Value
+	var idx int64
+	for {
+		row, ok, err := rows()
+		if err != nil {
+			fail(err)
+			break
+		}
+		if !ok {
+			if buf.IsValid() && buf.Len() > 0 {
+				send(bulkChunk{idx: idx, rows: buf})
+			}
+			break
+		}
+		rv := reflect.ValueOf(row)
+		if !rv.IsValid() || (buf.IsValid() && rv.Type() != buf.Type().Elem()) {
+			fail(ErrBulkRowType)
+			break
+		}
+		if !buf.IsValid() {
+			buf = reflect.MakeSlice(reflect.SliceOf(rv.Type()), 0, size)
+		}
+		buf = reflect.Append(buf, rv)
+		if buf.Len() < size {
+			continue
+		}
+		if !send(bulkChunk{idx: idx, rows: buf}) {
+			break
+		}
+		buf = reflect.MakeSlice(buf.Type(), 0, size)
+		idx++
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		// 调用方取消
+		firstErr = ctx.Err()
+	}
+	return res, firstErr
+}
+
+// sealBulkChunk 写入一批数据，BulkTxChunk模式下在独立事务中执行
+func (opts RepoSealOptions) sealBulkChunk(ctx context.Context, data interface{}) (int64, error) {
+	var lastId int64
+	err := opts.sealBulkTx(ctx, BulkTxChunk, func(ctx context.Context) error {
+		var err error
+		lastId, err = opts.sealRun(ctx, sealOpWrite, HookOpInsert, true, data, nil, sealMultiInsert)
+		return err
+	})
+	return lastId, err
+}
+
+// sealBulkTx 事务模式为mode且当前不在事务中时，在事务中执行fn
+func (opts RepoSealOptions) sealBulkTx(ctx context.Context, mode BulkTxMode, fn func(ctx context.Context) error) error {
+	_, inTx := TxFromContext(ctx)
+	if opts.BulkTx != mode || inTx || opts.TX != nil {
+		return fn(ctx)
+	}
+	sopts, err := opts.sealShard(ctx)
+	if err != nil {
+		return err
+	}
+	db, ok := sopts.sealTxDB()
+	if !ok {
+		return ErrBothDbAndTxNil
+	}
+	return WithinTx(ctx, db, fn)
+}
+
+// sealMultiInsert 一条语句写入多条数据
+func sealMultiInsert(ctx context.Context, opts RepoSealOptions, sq query.Query, params interface{}) (int64, error) {
+	return sealInsert(ctx, sq, opts.Name, true, params, opts.returningColumn())
+}
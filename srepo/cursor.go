@@ -0,0 +1,234 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrScanKeyNil error = serr.New(serr.ErrInvalid, "scan key column is nil")
+var ErrScanStop error = errors.New("scan stopped by callback")
+
+// DefaultScanBatch 全表扫描默认每批读取的条数
+const DefaultScanBatch = 1000
+
+// RowCursor 逐行读取结果的游标，使用完毕后需调用Close释放连接
+//
+//	it, err := reader(ctx, SealEq("status", 1))
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		var row T
+//		if err := it.Scan(&row); err != nil {
+//			return err
+//		}
+//	}
+//	return it.Err()
+type RowCursor struct {
+	ctx  context.Context
+	rows *sql.Rows
+	cols []string
+	err  error
+}
+
+// Next 移动到下一行，没有更多数据、出错或ctx取消时返回false
+func (it *RowCursor) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		it.rows.Close()
+		return false
+	}
+	return it.rows.Next()
+}
+
+// ScanMap 读取当前行为map
+func (it *RowCursor) ScanMap() (map[string]interface{}, error) {
+	refs := make([]interface{}, len(it.cols))
+	for i := range refs {
+		var v interface{}
+		refs[i] = &v
+	}
+	err := it.rows.Scan(refs...)
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(it.cols))
+	for i, col := range it.cols {
+		row[col] = *refs[i].(*interface{})
+	}
+	return row, nil
+}
+
+// Scan 读取当前行到data，data为结构体指针，原有字段值会被清空
+func (it *RowCursor) Scan(data interface{}) error {
+	row, err := it.ScanMap()
+	if err != nil {
+		return err
+	}
+	sealResetData(data)
+	return utils.Map2Struct(row, data)
+}
+
+// NextBatch 读取至多size行到data，data为切片指针，原有数据会被清空
+// 返回读取的行数，为0时表示没有更多数据，错误通过返回值及Err获取
+func (it *RowCursor) NextBatch(data interface{}, size int) (int, error) {
+	rows := make([]map[string]interface{}, 0, size)
+	for len(rows) < size && it.Next() {
+		row, err := it.ScanMap()
+		if err != nil {
+			it.err = err
+			return 0, err
+		}
+		rows = append(rows, row)
+	}
+	if err := it.Err(); err != nil {
+		return 0, err
+	}
+	sealResetData(data)
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return len(rows), utils.Map2Struct(rows, data)
+}
+
+// Err 迭代过程中的错误
+func (it *RowCursor) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+// Close 关闭游标
+func (it *RowCursor) Close() error {
+	return it.rows.Close()
+}
+
+// RepoCursorReader 逐行读取数据，适用于导出等结果集较大的场景
+// @params where 查询子句
+type RepoCursorReader func(ctx context.Context, where ...ClauseHandler) (*RowCursor, error)
+
+// NewSealMysqlCursorReader 创建新的Seal逐行读取对象
+// 迭代期间持有数据库连接，事务中使用时需在读取其他数据前关闭
+func NewSealMysqlCursorReader(hands ...RepoSealOptionHandler) RepoCursorReader {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, handler ...ClauseHandler) (*RowCursor, error) {
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return nil, err
+		}
+		q := sq.Select(opts.Columns...).From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return nil, err
+		}
+		rows := q.Query(ctx)
+		if rows.Rows == nil {
+			// 查询失败，AllMap直接返回错误
+			_, err = rows.AllMap()
+			return nil, err
+		}
+		cols, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		return &RowCursor{ctx: ctx, rows: rows.Rows, cols: cols}, nil
+	}
+}
+
+// RepoScanner 按主键分批扫描全表
+// @params data 承载每批数据的切片指针，每批读取后覆盖
+// @params fn 每批数据的处理方法，返回ErrScanStop时停止扫描并返回nil，返回其他错误时停止扫描并返回该错误
+// @params where 查询子句，不应包含排序及分页
+type RepoScanner func(ctx context.Context, data interface{}, fn func() error, where ...ClauseHandler) error
+
+// NewSealMysqlScanner 创建新的Seal全表扫描对象
+// 按WithScanKey指定的字段升序分批读取，翻页使用【WHERE key > 上一批最大值】，不受数据量影响
+func NewSealMysqlScanner(hands ...RepoSealOptionHandler) RepoScanner {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, fn func() error, handler ...ClauseHandler) error {
+		if opts.ScanKey == "" {
+			return ErrScanKeyNil
+		}
+		batch := opts.ScanBatch
+		if batch <= 0 {
+			batch = DefaultScanBatch
+		}
+		var last interface{}
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+			if err != nil {
+				return err
+			}
+			q := sq.Select(opts.Columns...).From(sealFrom(opts))
+			err = sealApplySelect(opts, q, handler)
+			if err != nil {
+				return err
+			}
+			if last != nil {
+				q.Where(seal.Op(opts.ScanKey, ">", last))
+			}
+			qctx, cancel := opts.sealTimeout(ctx)
+			rows, err := q.OrderBy(opts.ScanKey).Limit(batch).Query(qctx).AllMap()
+			cancel()
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				return nil
+			}
+			last = hookValue(rows[len(rows)-1][opts.ScanKey])
+			if last == nil {
+				return ErrColumnMissing
+			}
+			sealResetData(data)
+			err = utils.Map2Struct(rows, data)
+			if err != nil {
+				return err
+			}
+			err = fn()
+			if err == ErrScanStop {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if int64(len(rows)) < batch {
+				return nil
+			}
+		}
+	}
+}
+
+// sealResetData 清空data指向的值，避免复用时残留上一次的数据
+func sealResetData(data interface{}) {
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
@@ -0,0 +1,256 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/builder"
+	"github.com/rumis/seal/options"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/serr"
+)
+
+// Dialect 数据库方言，根据seal.DB的Builder确定
+// NewSealMysql*系列对象在各方言下通用，语法差异(占位符、自增ID、冲突处理)由方言处理
+type Dialect int8
+
+const (
+	DialectMysql    Dialect = 1 // MySQL，未识别的Builder均按MySQL处理
+	DialectPostgres Dialect = 2 // PostgreSQL，需通过OpenPostgres创建seal.DB
+	DialectSqlite   Dialect = 3 // SQLite，seal.Open("sqlite3", ...)或builder.NewSqliteBuilder()
+)
+
+// 错误定义
+var ErrReturningInvalid error = serr.New(serr.ErrInvalid, "returning value is not an integer")
+var ErrUpsertKeyColumnsNil error = serr.New(serr.ErrInvalid, "upsert key columns is nil")
+
+// DefaultReturningColumn PostgreSQL写入时默认通过RETURNING返回的自增字段
+const DefaultReturningColumn = "id"
+
+// DialectOf 获取Builder对应的方言
+func DialectOf(b builder.Builder) Dialect {
+	switch b.(type) {
+	case *BuilderPostgres:
+		return DialectPostgres
+	case builder.BuilderSqlite, *builder.BuilderSqlite:
+		return DialectSqlite
+	}
+	return DialectMysql
+}
+
+// BuilderPostgres PostgreSQL语法构建器，SQL仍以?作为占位符构建，执行时转换为$n
+type BuilderPostgres struct {
+	builder.BuilderStandard
+
+	db *sql.DB // 开启事务使用
+}
+
+// OpenPostgres 使用PostgreSQL连接创建seal.DB
+// 占位符在执行时转换为$n，事务需通过WithinTx开启，seal.DB.Begin创建的事务仅可通过WithTX使用
+func OpenPostgres(db *sql.DB, opts ...options.SealOptionsFunc) (seal.DB, error) {
+	b := &BuilderPostgres{db: db}
+	sdb, err := seal.OpenWithDB(db, b, opts...)
+	if err != nil {
+		return sdb, err
+	}
+	sdb.Query = query.NewQuery(b, postgresExecutor{e: db}, sdb.Options())
+	return sdb, nil
+}
+
+// sealBeginPostgres 在PostgreSQL连接上开启事务，db不是通过OpenPostgres创建时返回false
+func sealBeginPostgres(ctx context.Context, db seal.DB, txOpts *sql.TxOptions) (query.Query, *sql.Tx, bool, error) {
+	b, ok := db.Builder().(*BuilderPostgres)
+	if !ok || b.db == nil {
+		return query.Query{}, nil, false, nil
+	}
+	tx, err := b.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return query.Query{}, nil, true, err
+	}
+	return query.NewQuery(b, postgresExecutor{e: tx}, db.Options()), tx, true, nil
+}
+
+// sealDialectTx 调用方通过seal.DB.Begin创建的事务，PostgreSQL下转换占位符
+func sealDialectTx(q query.Query) query.Query {
+	if DialectOf(q.Builder()) != DialectPostgres {
+		return q
+	}
+	// 执行日志由内层查询对象输出，避免重复
+	sopts := *q.Options()
+	sopts.ExecLog = nil
+	return query.NewQuery(q.Builder(), postgresExecutor{e: queryExecutor{q: q}}, &sopts)
+}
+
+// postgresExecutor 将?占位符转换为$n的执行器
+type postgresExecutor struct {
+	e query.Executor
+}
+
+func (e postgresExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.e.Exec(postgresPlaceholders(sql), args...)
+}
+
+func (e postgresExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
+	return e.e.ExecContext(ctx, postgresPlaceholders(sql), args...)
+}
+
+func (e postgresExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.e.Query(postgresPlaceholders(sql), args...)
+}
+
+func (e postgresExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.e.QueryContext(ctx, postgresPlaceholders(sql), args...)
+}
+
+// postgresPlaceholders ?转换为$1、$2...，引号中的?保持不变
+func postgresPlaceholders(s string) string {
+	if !strings.Contains(s, "?") {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// queryExecutor 将查询对象适配为执行器
+type queryExecutor struct {
+	q query.Query
+}
+
+func (e queryExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.ExecContext(context.Background(), sql, args...)
+}
+
+func (e queryExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
+	res := e.q.ExecContext(ctx, sql, args...)
+	// query.Result在执行失败时各方法均返回执行错误
+	_, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e queryExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), sql, args...)
+}
+
+func (e queryExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
+	rows := e.q.QueryContext(ctx, sql, args...)
+	if rows.Rows == nil {
+		// 查询失败，AllMap直接返回查询错误
+		_, err := rows.AllMap()
+		return nil, err
+	}
+	return rows.Rows, nil
+}
+
+// sealInsert 写入数据并返回最后一个自增ID
+// PostgreSQL不支持LastInsertId，通过RETURNING returning获取，returning为空时不返回自增ID
+func sealInsert(ctx context.Context, sq query.Query, table string, multi bool, data interface{}, returning string) (int64, error) {
+	var lastId int64
+	if DialectOf(sq.Builder()) != DialectPostgres {
+		q := sq.Insert(table)
+		if multi {
+			q.Values(data)
+		} else {
+			q.Value(data)
+		}
+		err := q.Exec(ctx, &lastId)
+		return lastId, err
+	}
+	bi := builder.NewInsert(sq.Builder(), sq.Options().EncodeHook).Into(table)
+	if multi {
+		bi.Values(data)
+	} else {
+		bi.Value(data)
+	}
+	sql, args, err := bi.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	if returning == "" {
+		_, err = sq.ExecContext(ctx, sql, args...).RowsAffected()
+		return 0, err
+	}
+	rows, err := sealReturning(ctx, sq, sql+" RETURNING "+returning, args)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	return sealInt64(rows[len(rows)-1][0])
+}
+
+// sealReturning 执行带RETURNING的语句，返回全部行
+func sealReturning(ctx context.Context, sq query.Query, sql string, args []interface{}) ([][]interface{}, error) {
+	rows := sq.QueryContext(ctx, sql, args...)
+	if rows.Rows == nil {
+		// 查询失败，AllMap直接返回查询错误
+		_, err := rows.AllMap()
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var res [][]interface{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		refs := make([]interface{}, len(cols))
+		for i := range vals {
+			refs[i] = &vals[i]
+		}
+		err = rows.Scan(refs...)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, vals)
+	}
+	return res, rows.Err()
+}
+
+// sealInt64 数据库返回的整数转换为int64
+func sealInt64(v interface{}) (int64, error) {
+	switch tv := v.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return tv, nil
+	case []byte:
+		return strconv.ParseInt(string(tv), 10, 64)
+	case string:
+		return strconv.ParseInt(tv, 10, 64)
+	}
+	f, ok := sealFloat(v)
+	if !ok {
+		return 0, ErrReturningInvalid
+	}
+	return int64(f), nil
+}
+
+// returningColumn PostgreSQL写入时RETURNING的字段，为空时不使用RETURNING
+func (opts RepoSealOptions) returningColumn() string {
+	if opts.ReturningColumn == "" && !opts.ReturningSet {
+		return DefaultReturningColumn
+	}
+	return opts.ReturningColumn
+}
@@ -0,0 +1,348 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/pkg/ujson"
+)
+
+// HookOp 钩子对应的操作
+type HookOp int8
+
+const (
+	HookOpInsert HookOp = 1 // 写入，包括插入或更新、忽略写入
+	HookOpUpdate HookOp = 2 // 更新
+	HookOpDelete HookOp = 3 // 删除，包括软删除
+	HookOpRead   HookOp = 4 // 读取，仅NewSealMysqlOneReader及NewSealMysqlMultiReader
+)
+
+// HookContext 钩子参数
+type HookContext struct {
+	Op     HookOp
+	Table  string
+	Multi  bool                   // 是否一次写入多条数据
+	Query  query.Query            // 当前执行对象，事务中为事务对象
+	Data   interface{}            // 写入或更新的数据，读取时为承载数据的指针，before钩子可替换
+	Where  []ClauseHandler        // 更新、删除及读取的条件
+	Result int64                  // 写入时为最后一个自增ID，更新及删除时为影响行数
+	Err    error                  // 执行错误，仅after钩子可见
+	Values map[string]interface{} // 钩子之间传递数据
+}
+
+// RepoHook 钩子方法，返回错误时终止执行
+type RepoHook func(ctx context.Context, hc *HookContext) error
+
+// WithBeforeHook 操作执行前的钩子，按注册顺序执行
+func WithBeforeHook(op HookOp, h RepoHook) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		if opts.BeforeHooks == nil {
+			opts.BeforeHooks = make(map[HookOp][]RepoHook)
+		}
+		opts.BeforeHooks[op] = append(opts.BeforeHooks[op], h)
+	}
+}
+
+// WithAfterHook 操作执行后的钩子，按注册顺序执行，执行失败时同样调用
+func WithAfterHook(op HookOp, h RepoHook) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		if opts.AfterHooks == nil {
+			opts.AfterHooks = make(map[HookOp][]RepoHook)
+		}
+		opts.AfterHooks[op] = append(opts.AfterHooks[op], h)
+	}
+}
+
+// sealRun 执行操作及钩子
+// 钩子要求事务且当前不在事务中时，自动在主库开启事务
+func (opts RepoSealOptions) sealRun(ctx context.Context, op sealOp, hop HookOp, multi bool, data interface{}, where []ClauseHandler,
+	fn func(ctx context.Context, opts RepoSealOptions, sq query.Query, data interface{}) (int64, error)) (int64, error) {
+	ctx, cancel := opts.sealTimeout(ctx)
+	defer cancel()
+	opts, err := opts.sealShard(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if opts.HookTx && opts.TX == nil {
+		if _, ok := TxFromContext(ctx); !ok {
+			if db, ok := opts.sealTxDB(); ok {
+				var res int64
+				err = WithinTx(ctx, db, func(ctx context.Context) error {
+					var err error
+					res, err = opts.sealRunHooks(ctx, op, hop, multi, data, where, fn)
+					return err
+				})
+				return res, err
+			}
+		}
+	}
+	return opts.sealRunHooks(ctx, op, hop, multi, data, where, fn)
+}
+
+// sealRunHooks 依次执行before钩子、操作及after钩子
+func (opts RepoSealOptions) sealRunHooks(ctx context.Context, op sealOp, hop HookOp, multi bool, data interface{}, where []ClauseHandler,
+	fn func(ctx context.Context, opts RepoSealOptions, sq query.Query, data interface{}) (int64, error)) (int64, error) {
+	sq, err := opts.sealQuery(ctx, op)
+	if err != nil {
+		return 0, err
+	}
+	if len(opts.BeforeHooks[hop]) == 0 && len(opts.AfterHooks[hop]) == 0 {
+		return fn(ctx, opts, sq, data)
+	}
+	hc := &HookContext{
+		Op:     hop,
+		Table:  opts.Name,
+		Multi:  multi,
+		Query:  sq,
+		Data:   data,
+		Where:  where,
+		Values: make(map[string]interface{}),
+	}
+	for _, h := range opts.BeforeHooks[hop] {
+		err = h(ctx, hc)
+		if err != nil {
+			return 0, err
+		}
+	}
+	hc.Result, hc.Err = fn(ctx, opts, sq, hc.Data)
+	for _, h := range opts.AfterHooks[hop] {
+		err = h(ctx, hc)
+		if err != nil && hc.Err == nil {
+			return hc.Result, err
+		}
+	}
+	return hc.Result, hc.Err
+}
+
+// operatorContextKey 操作人在context中的KEY
+type operatorContextKey struct{}
+
+// WithOperatorID 设置操作人，供WithOperator及WithAudit使用
+func WithOperatorID(ctx context.Context, id interface{}) context.Context {
+	return context.WithValue(ctx, operatorContextKey{}, id)
+}
+
+// OperatorFromContext 获取context中的操作人
+func OperatorFromContext(ctx context.Context) (interface{}, bool) {
+	id := ctx.Value(operatorContextKey{})
+	return id, id != nil
+}
+
+// WithTimestamps 写入时自动设置创建时间及更新时间，更新时自动设置更新时间
+// 字段名为空时不设置，数据中已有该字段时保留原值
+func WithTimestamps(createdColumn string, updatedColumn string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		WithBeforeHook(HookOpInsert, func(ctx context.Context, hc *HookContext) error {
+			now := time.Now()
+			return hookSetColumns(hc, map[string]interface{}{createdColumn: now, updatedColumn: now})
+		})(opts)
+		WithBeforeHook(HookOpUpdate, func(ctx context.Context, hc *HookContext) error {
+			return hookSetColumns(hc, map[string]interface{}{updatedColumn: time.Now()})
+		})(opts)
+	}
+}
+
+// WithOperator 写入时自动设置创建人及更新人，更新时自动设置更新人，操作人通过WithOperatorID设置
+// 字段名为空或context中没有操作人时不设置，数据中已有该字段时保留原值
+func WithOperator(createdByColumn string, updatedByColumn string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		WithBeforeHook(HookOpInsert, func(ctx context.Context, hc *HookContext) error {
+			id, ok := OperatorFromContext(ctx)
+			if !ok {
+				return nil
+			}
+			return hookSetColumns(hc, map[string]interface{}{createdByColumn: id, updatedByColumn: id})
+		})(opts)
+		WithBeforeHook(HookOpUpdate, func(ctx context.Context, hc *HookContext) error {
+			id, ok := OperatorFromContext(ctx)
+			if !ok {
+				return nil
+			}
+			return hookSetColumns(hc, map[string]interface{}{updatedByColumn: id})
+		})(opts)
+	}
+}
+
+// hookSetColumns 为写入数据设置字段值，数据转换为map
+func hookSetColumns(hc *HookContext, vals map[string]interface{}) error {
+	set := func(row map[string]interface{}) {
+		for k, v := range vals {
+			if _, ok := row[k]; k != "" && !ok {
+				row[k] = v
+			}
+		}
+	}
+	if !hc.Multi {
+		row, err := sealUpdateMap(hc.Data)
+		if err != nil {
+			return err
+		}
+		set(row)
+		hc.Data = row
+		return nil
+	}
+	rows, err := sealRowMaps(hc.Data)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		set(row)
+	}
+	hc.Data = rows
+	return nil
+}
+
+// 审计日志的操作类型
+const (
+	AuditOpUpdate = "update"
+	AuditOpDelete = "delete"
+)
+
+const auditOldRowsKey = "srepo_audit_old_rows"
+
+// WithAudit 更新及删除时将变化数据的新旧值写入审计表，与业务操作在同一事务中执行
+// 不在事务中时自动开启事务，审计表字段：table_name、row_id、op、old_value、new_value、operator、created_at
+// pkColumn为主键字段，用于关联更新前后的数据；MySQL及PostgreSQL下以SELECT ... FOR UPDATE读取更新前的数据，
+// 锁定至事务结束，避免并发修改使记录的旧值失真
+func WithAudit(auditTable string, pkColumn string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.HookTx = true
+		before := func(ctx context.Context, hc *HookContext) error {
+			rows, err := hookSelectRows(ctx, hookForUpdate(hc.Query), hc.Table, hc.Where...)
+			if err != nil {
+				return err
+			}
+			hc.Values[auditOldRowsKey] = rows
+			return nil
+		}
+		after := func(op string) RepoHook {
+			return func(ctx context.Context, hc *HookContext) error {
+				olds, _ := hc.Values[auditOldRowsKey].([]map[string]interface{})
+				if hc.Err != nil || hc.Result == 0 || len(olds) == 0 {
+					return nil
+				}
+				return hookWriteAudit(ctx, hc, auditTable, pkColumn, op, olds)
+			}
+		}
+		WithBeforeHook(HookOpUpdate, before)(opts)
+		WithAfterHook(HookOpUpdate, after(AuditOpUpdate))(opts)
+		WithBeforeHook(HookOpDelete, before)(opts)
+		WithAfterHook(HookOpDelete, after(AuditOpDelete))(opts)
+	}
+}
+
+// hookWriteAudit 写入审计日志，仅记录数据有变化的行
+func hookWriteAudit(ctx context.Context, hc *HookContext, auditTable string, pkColumn string, op string, olds []map[string]interface{}) error {
+	pks := make([]interface{}, 0, len(olds))
+	for _, row := range olds {
+		pks = append(pks, hookValue(row[pkColumn]))
+	}
+	news, err := hookSelectRows(ctx, hc.Query, hc.Table, SealIn(pkColumn, pks...))
+	if err != nil {
+		return err
+	}
+	newByPk := make(map[string]map[string]interface{}, len(news))
+	for _, row := range news {
+		newByPk[fmt.Sprint(hookValue(row[pkColumn]))] = row
+	}
+	operator, _ := OperatorFromContext(ctx)
+	now := time.Now()
+	logs := make([]map[string]interface{}, 0, len(olds))
+	for _, old := range olds {
+		pk := fmt.Sprint(hookValue(old[pkColumn]))
+		oldVal, err := hookEncodeRow(old)
+		if err != nil {
+			return err
+		}
+		var newVal interface{}
+		if row, ok := newByPk[pk]; ok {
+			buf, err := hookEncodeRow(row)
+			if err != nil {
+				return err
+			}
+			if buf == oldVal {
+				continue
+			}
+			newVal = buf
+		}
+		logs = append(logs, map[string]interface{}{
+			"table_name": hc.Table,
+			"row_id":     pk,
+			"op":         op,
+			"old_value":  oldVal,
+			"new_value":  newVal,
+			"operator":   operator,
+			"created_at": now,
+		})
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	_, err = sealInsert(ctx, hc.Query, auditTable, true, logs, "")
+	return err
+}
+
+// hookSelectRows 按条件读取整行数据
+func hookSelectRows(ctx context.Context, sq query.Query, table string, where ...ClauseHandler) ([]map[string]interface{}, error) {
+	q := sq.Select("*").From(table)
+	for _, v := range where {
+		err := v(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q.Query(ctx).AllMap()
+}
+
+// hookForUpdate 读取时加行锁，SQLite不支持FOR UPDATE且写事务本身独占，保持不变
+func hookForUpdate(sq query.Query) query.Query {
+	if DialectOf(sq.Builder()) == DialectSqlite {
+		return sq
+	}
+	// 执行日志由内层查询对象输出，避免重复
+	sopts := *sq.Options()
+	sopts.ExecLog = nil
+	return query.NewQuery(sq.Builder(), forUpdateExecutor{e: queryExecutor{q: sq}}, &sopts)
+}
+
+// forUpdateExecutor 在查询语句后追加FOR UPDATE的执行器
+type forUpdateExecutor struct {
+	e query.Executor
+}
+
+func (e forUpdateExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.e.Exec(sql, args...)
+}
+
+func (e forUpdateExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
+	return e.e.ExecContext(ctx, sql, args...)
+}
+
+func (e forUpdateExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.e.Query(sql+" FOR UPDATE", args...)
+}
+
+func (e forUpdateExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.e.QueryContext(ctx, sql+" FOR UPDATE", args...)
+}
+
+// hookEncodeRow 行数据编码为json
+func hookEncodeRow(row map[string]interface{}) (string, error) {
+	vals := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		vals[k] = hookValue(v)
+	}
+	buf, err := ujson.Marshal(vals)
+	return string(buf), err
+}
+
+// hookValue 数据库返回的[]byte转换为string
+func hookValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
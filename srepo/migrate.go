@@ -0,0 +1,443 @@
+package srepo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/locker"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrMigrationDuplicate error = serr.New(serr.ErrInvalid, "migration version is duplicated")
+var ErrMigrationUpNil error = serr.New(serr.ErrInvalid, "migration up is nil")
+var ErrMigrationDownNil error = serr.New(serr.ErrInvalid, "migration down is nil")
+var ErrMigrationChecksum error = serr.New(serr.ErrConflict, "applied migration checksum mismatch")
+var ErrMigrationLocked error = serr.New(serr.ErrConflict, "migration is locked by others")
+
+// DefaultMigrationTable 默认迁移记录表
+const DefaultMigrationTable = "schema_migrations"
+
+// DefaultMigrationLockKey 默认迁移锁KEY
+const DefaultMigrationLockKey = "srepo_schema_migrations"
+
+// MigrationFunc Go代码实现的迁移，q在事务中执行
+type MigrationFunc func(ctx context.Context, q query.Query) error
+
+// Migration 一个版本的迁移，Up/Down为SQL，UpFn/DownFn为Go代码，同时设置时先执行SQL
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	UpFn    MigrationFunc
+	DownFn  MigrationFunc
+}
+
+// Checksum 迁移内容的校验和，Go代码实现的迁移以版本及名称计算
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	if m.Up == "" {
+		sum = sha256.Sum256([]byte(fmt.Sprintf("%d_%s", m.Version, m.Name)))
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool      // 已执行
+	AppliedAt time.Time // 执行时间，数据库返回的时间无法解析时为零值
+	Modified  bool      // 已执行且内容被修改
+	Missing   bool      // 已执行但本地不存在
+}
+
+// MigratorOptions 迁移配置
+type MigratorOptions struct {
+	DB         seal.DB
+	Table      string         // 迁移记录表
+	Locker     *locker.Locker // 分布式锁，多实例同时启动时避免重复执行
+	LockKey    string
+	DryRun     bool // 仅返回待执行的迁移，不执行
+	Migrations []Migration
+	Err        error // 配置过程中的错误，如读取迁移文件失败
+}
+
+// MigratorOptionHandler 迁移配置选项
+type MigratorOptionHandler func(*MigratorOptions)
+
+// DefaultMigratorOptions 创建默认的迁移配置
+func DefaultMigratorOptions() MigratorOptions {
+	return MigratorOptions{
+		Table:   DefaultMigrationTable,
+		LockKey: DefaultMigrationLockKey,
+	}
+}
+
+// WithMigrateDB 执行迁移的数据库
+func WithMigrateDB(db seal.DB) MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		opts.DB = db
+	}
+}
+
+// WithMigrateTable 迁移记录表
+func WithMigrateTable(table string) MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		opts.Table = table
+	}
+}
+
+// WithMigrateLocker 执行迁移前加锁，锁的过期时间需大于迁移耗时
+func WithMigrateLocker(l locker.Locker, key string) MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		opts.Locker = &l
+		if key != "" {
+			opts.LockKey = key
+		}
+	}
+}
+
+// WithMigrateDryRun 仅返回待执行的迁移，不执行，迁移记录表不存在时仍会创建
+func WithMigrateDryRun() MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		opts.DryRun = true
+	}
+}
+
+// WithMigrateFS 从文件系统读取迁移，通常为embed.FS
+// 文件名格式为【版本_名称.up.sql】及【版本_名称.down.sql】，如0001_create_user.up.sql
+func WithMigrateFS(fsys fs.FS, dir string) MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		ms, err := ParseMigrations(fsys, dir)
+		if err != nil {
+			opts.Err = err
+			return
+		}
+		opts.Migrations = append(opts.Migrations, ms...)
+	}
+}
+
+// WithMigrateFunc Go代码实现的迁移
+func WithMigrateFunc(version int64, name string, up MigrationFunc, down MigrationFunc) MigratorOptionHandler {
+	return func(opts *MigratorOptions) {
+		opts.Migrations = append(opts.Migrations, Migration{Version: version, Name: name, UpFn: up, DownFn: down})
+	}
+}
+
+// migrationFileRegex 迁移文件名
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ParseMigrations 读取目录下的迁移文件，不符合命名格式的文件将被忽略
+func ParseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		match := migrationFileRegex.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: %d", ErrMigrationDuplicate, version)
+		}
+		if match[3] == "up" {
+			m.Up = string(buf)
+		} else {
+			m.Down = string(buf)
+		}
+	}
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%w: %d", ErrMigrationUpNil, m.Version)
+		}
+		res = append(res, *m)
+	}
+	return res, nil
+}
+
+// Migrator 数据库迁移
+type Migrator struct {
+	opts MigratorOptions
+}
+
+// NewMigrator 创建新的迁移对象
+func NewMigrator(hands ...MigratorOptionHandler) (*Migrator, error) {
+	// 默认配置
+	opts := DefaultMigratorOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	if opts.Err != nil {
+		return nil, opts.Err
+	}
+	sort.Slice(opts.Migrations, func(i, j int) bool {
+		return opts.Migrations[i].Version < opts.Migrations[j].Version
+	})
+	for i, m := range opts.Migrations {
+		if i > 0 && opts.Migrations[i-1].Version == m.Version {
+			return nil, fmt.Errorf("%w: %d", ErrMigrationDuplicate, m.Version)
+		}
+		if m.Up == "" && m.UpFn == nil {
+			return nil, fmt.Errorf("%w: %d", ErrMigrationUpNil, m.Version)
+		}
+	}
+	return &Migrator{opts: opts}, nil
+}
+
+// Up 执行全部未执行的迁移，返回执行(DryRun时为待执行)的迁移
+// 已执行的迁移内容被修改时返回ErrMigrationChecksum，不执行任何迁移
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo 执行版本不大于version的未执行迁移，version为0时执行全部
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func() error {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		var pending []Migration
+		for _, s := range status {
+			if s.Modified {
+				return fmt.Errorf("%w: %d", ErrMigrationChecksum, s.Version)
+			}
+			if s.Applied || s.Missing || (version > 0 && s.Version > version) {
+				continue
+			}
+			pending = append(pending, m.migration(s.Version))
+		}
+		if m.opts.DryRun {
+			done = pending
+			return nil
+		}
+		for _, mg := range pending {
+			err = m.apply(ctx, mg, true)
+			if err != nil {
+				return err
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 回滚最近执行的steps个迁移，返回回滚(DryRun时为待回滚)的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func() error {
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		var pending []Migration
+		for i := len(status) - 1; i >= 0 && len(pending) < steps; i-- {
+			s := status[i]
+			if !s.Applied {
+				continue
+			}
+			if s.Missing {
+				return fmt.Errorf("%w: %d", ErrMigrationDownNil, s.Version)
+			}
+			mg := m.migration(s.Version)
+			if mg.Down == "" && mg.DownFn == nil {
+				return fmt.Errorf("%w: %d", ErrMigrationDownNil, s.Version)
+			}
+			pending = append(pending, mg)
+		}
+		if m.opts.DryRun {
+			done = pending
+			return nil
+		}
+		for _, mg := range pending {
+			err = m.apply(ctx, mg, false)
+			if err != nil {
+				return err
+			}
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status 全部迁移的状态，按版本升序
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	err := m.ensureTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.opts.DB.Select("version", "name", "checksum", "applied_at").From(m.opts.Table).Query(ctx).AllMap()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		v, err := sealInt64(row["version"])
+		if err != nil {
+			return nil, err
+		}
+		applied[v] = row
+	}
+	res := make([]MigrationStatus, 0, len(m.opts.Migrations)+len(applied))
+	for _, mg := range m.opts.Migrations {
+		s := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if row, ok := applied[mg.Version]; ok {
+			s.Applied = true
+			s.AppliedAt, _ = row["applied_at"].(time.Time)
+			s.Modified = fmt.Sprint(hookValue(row["checksum"])) != mg.Checksum()
+			delete(applied, mg.Version)
+		}
+		res = append(res, s)
+	}
+	for v, row := range applied {
+		at, _ := row["applied_at"].(time.Time)
+		res = append(res, MigrationStatus{Version: v, Name: fmt.Sprint(hookValue(row["name"])), Applied: true, AppliedAt: at, Missing: true})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+	return res, nil
+}
+
+// locked 加锁执行fn，未配置锁时直接执行
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	l := m.opts.Locker
+	if l == nil {
+		return fn()
+	}
+	for i := 0; !l.Adder(ctx, m.opts.LockKey); i++ {
+		if i >= l.RetryTimes {
+			return ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.RetrySpan):
+		}
+	}
+	defer l.Deleter(ctx, m.opts.LockKey)
+	return fn()
+}
+
+// ensureTable 创建迁移记录表
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.opts.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.opts.Table+
+		" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at TIMESTAMP NULL)").RowsAffected()
+	return err
+}
+
+// apply 在事务中执行迁移并记录，MySQL的DDL会隐式提交，失败时需人工处理
+func (m *Migrator) apply(ctx context.Context, mg Migration, up bool) error {
+	return WithinTx(ctx, m.opts.DB, func(ctx context.Context) error {
+		q, _ := TxFromContext(ctx)
+		sql, fn := mg.Up, mg.UpFn
+		if !up {
+			sql, fn = mg.Down, mg.DownFn
+		}
+		for _, stmt := range splitStatements(sql) {
+			_, err := q.ExecContext(ctx, stmt).RowsAffected()
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+		}
+		if fn != nil {
+			err := fn(ctx, q)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+		}
+		if !up {
+			var cnt int64
+			return q.Delete(m.opts.Table).Where(seal.Eq("version", mg.Version)).Exec(ctx, &cnt)
+		}
+		_, err := sealInsert(ctx, q, m.opts.Table, false, map[string]interface{}{
+			"version":    mg.Version,
+			"name":       mg.Name,
+			"checksum":   mg.Checksum(),
+			"applied_at": time.Now(),
+		}, "")
+		return err
+	})
+}
+
+// migration 获取指定版本的迁移
+func (m *Migrator) migration(version int64) Migration {
+	for _, mg := range m.opts.Migrations {
+		if mg.Version == version {
+			return mg
+		}
+	}
+	return Migration{Version: version}
+}
+
+// splitStatements 按分号拆分SQL语句，忽略引号及注释中的分号
+func splitStatements(sql string) []string {
+	var res []string
+	var b strings.Builder
+	var quote rune
+	comment := false
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			res = append(res, stmt)
+		}
+		b.Reset()
+	}
+	runes := []rune(sql)
+	for i, c := range runes {
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			}
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			comment = true
+			continue
+		case c == ';':
+			flush()
+			continue
+		}
+		b.WriteRune(c)
+	}
+	flush()
+	return res
+}
@@ -0,0 +1,200 @@
+package srepo
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrModelType error = serr.New(serr.ErrInvalid, "model should be a struct or a pointer to struct")
+
+// TableNamer 模型自定义表名，未实现时表名为结构体名称的蛇形命名，如UserInfo为user_info
+type TableNamer interface {
+	TableName() string
+}
+
+// Model 模型元数据，由结构体的seal标签解析
+// 标签格式为【字段名,选项...】，如seal:"id,pk,autoincr"，字段名为-时忽略该字段，未设置标签时字段名为结构体字段名
+// 选项：pk主键、autoincr自增、version乐观锁版本号、softdelete软删除时间，omitempty等其他选项由seal处理
+type Model struct {
+	Table      string
+	Columns    []string // 全部字段，按结构体定义顺序
+	PrimaryKey []string // 主键字段
+	AutoIncr   string   // 自增字段
+	Version    string   // 乐观锁版本号字段
+	SoftDelete string   // 软删除字段
+}
+
+// modelCache 已解析的模型，KEY为结构体类型
+var modelCache sync.Map
+
+// ParseModel 解析模型元数据，同一结构体只解析一次
+func ParseModel(model interface{}) (*Model, error) {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrModelType
+	}
+	if m, ok := modelCache.Load(t); ok {
+		return m.(*Model), nil
+	}
+	m := &Model{Table: modelSnakeCase(t.Name())}
+	if tn, ok := reflect.New(t).Interface().(TableNamer); ok {
+		m.Table = tn.TableName()
+	}
+	modelFields(t, m)
+	mm, _ := modelCache.LoadOrStore(t, m)
+	return mm.(*Model), nil
+}
+
+// modelFields 解析结构体字段，squash的嵌入结构体展开解析
+func modelFields(t reflect.Type, m *Model) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// 未导出字段
+			continue
+		}
+		parts := strings.Split(f.Tag.Get("seal"), ",")
+		name, opts := parts[0], parts[1:]
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && modelHasOption(opts, "squash") {
+			modelFields(ft, m)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		m.Columns = append(m.Columns, name)
+		for _, opt := range opts {
+			switch strings.TrimSpace(opt) {
+			case "pk":
+				m.PrimaryKey = append(m.PrimaryKey, name)
+			case "autoincr":
+				m.AutoIncr = name
+			case "version":
+				m.Version = name
+			case "softdelete":
+				m.SoftDelete = name
+			}
+		}
+	}
+}
+
+// modelHasOption 标签是否包含选项
+func modelHasOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if strings.TrimSpace(o) == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// modelSnakeCase 驼峰命名转为蛇形命名，连续大写视为一个单词，如HTTPLog为http_log
+func modelSnakeCase(s string) string {
+	rs := []rune(s)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// WithModel 根据模型设置表名、读取字段、乐观锁、软删除、批量更新及扫描的主键、插入或更新的冲突字段
+// 仅设置未配置的选项，显式配置的WithName、WithColumns等选项优先
+// 自增字段为零值时写入前移除该字段，由数据库生成；model不是结构体时读写对象返回ErrModelType
+func WithModel(model interface{}) RepoSealOptionHandler {
+	m, err := ParseModel(model)
+	return func(opts *RepoSealOptions) {
+		if err != nil {
+			opts.Err = err
+			return
+		}
+		if opts.Name == "" {
+			opts.Name = m.Table
+		}
+		if len(opts.Columns) == 0 {
+			opts.Columns = m.Columns
+		}
+		if opts.VersionColumn == "" {
+			opts.VersionColumn = m.Version
+		}
+		if opts.SoftDeleteColumn == "" {
+			opts.SoftDeleteColumn = m.SoftDelete
+		}
+		if len(opts.UpsertKeyColumns) == 0 {
+			opts.UpsertKeyColumns = m.PrimaryKey
+		}
+		if len(m.PrimaryKey) == 1 {
+			if opts.BatchKey == "" {
+				opts.BatchKey = m.PrimaryKey[0]
+			}
+			if opts.ScanKey == "" {
+				opts.ScanKey = m.PrimaryKey[0]
+			}
+		}
+		if m.AutoIncr == "" {
+			return
+		}
+		if !opts.ReturningSet {
+			opts.ReturningColumn = m.AutoIncr
+		}
+		WithBeforeHook(HookOpInsert, func(ctx context.Context, hc *HookContext) error {
+			return modelOmitZero(hc, m.AutoIncr)
+		})(opts)
+	}
+}
+
+// modelOmitZero 写入数据中column为零值时移除该字段，多条数据时全部为零值才移除
+func modelOmitZero(hc *HookContext, column string) error {
+	if !hc.Multi {
+		row, err := sealUpdateMap(hc.Data)
+		if err != nil {
+			return err
+		}
+		if modelIsZero(row[column]) {
+			delete(row, column)
+		}
+		hc.Data = row
+		return nil
+	}
+	rows, err := sealRowMaps(hc.Data)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if !modelIsZero(row[column]) {
+			return nil
+		}
+	}
+	for _, row := range rows {
+		delete(row, column)
+	}
+	hc.Data = rows
+	return nil
+}
+
+// modelIsZero 是否为零值
+func modelIsZero(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
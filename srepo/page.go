@@ -0,0 +1,218 @@
+package srepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/expr"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrPageOrderNil error = serr.New(serr.ErrInvalid, "page order columns is nil")
+var ErrPageCursorInvalid error = serr.New(serr.ErrInvalid, "page cursor is invalid")
+var ErrPageColumnMissing error = serr.New(serr.ErrInvalid, "page order column is missing in result")
+var ErrPageSizeInvalid error = serr.New(serr.ErrInvalid, "page size must be positive")
+var ErrPageNullValue error = serr.New(serr.ErrInvalid, "page order column value is null")
+
+// Page 分页结果
+type Page struct {
+	NextCursor string // 下一页游标，没有更多数据时为空
+	HasMore    bool   // 是否还有更多数据
+	Total      int64  // 满足条件的总条数，WithPageTotal时返回
+}
+
+// RepoPager 游标分页读取
+// @params data 承载数据的指针
+// @params cursor 上一页返回的NextCursor，首页为空
+// @params size 每页条数，必须大于0
+// @params where 查询子句，不应包含排序及分页
+type RepoPager func(ctx context.Context, data interface{}, cursor string, size int64, where ...ClauseHandler) (Page, error)
+
+// NewSealMysqlPager 创建新的Seal游标分页读取对象
+// 按WithPageOrder指定的字段排序，游标记录上一页最后一行的排序字段值，
+// 翻页使用【WHERE (排序字段) > 游标值】避免深度OFFSET扫描。排序字段组合需唯一，通常以主键结尾；
+// 排序字段不能为NULL，游标行的排序字段为NULL时返回ErrPageNullValue
+func NewSealMysqlPager(hands ...RepoSealOptionHandler) RepoPager {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, cursor string, size int64, handler ...ClauseHandler) (Page, error) {
+		var page Page
+		if len(opts.PageOrder) == 0 {
+			return page, ErrPageOrderNil
+		}
+		if size <= 0 {
+			return page, ErrPageSizeInvalid
+		}
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return page, err
+		}
+		cols, descs := sealPageOrder(opts.PageOrder)
+		// 总条数
+		if opts.PageTotal {
+			cq := sq.Count("*").From(sealFrom(opts))
+			for _, v := range handler {
+				err = v(cq)
+				if err != nil {
+					return page, err
+				}
+			}
+			sealSoftDeleteFilter(opts, cq)
+			err = cq.Query(ctx).Agg(&page.Total)
+			if err != nil {
+				return page, err
+			}
+		}
+		q := sq.Select(opts.Columns...).From(sealFrom(opts))
+		for _, v := range handler {
+			err = v(q)
+			if err != nil {
+				return page, err
+			}
+		}
+		sealSoftDeleteFilter(opts, q)
+		if cursor != "" {
+			vals, err := sealDecodeCursor(cursor, len(cols))
+			if err != nil {
+				return page, err
+			}
+			q.Where(sealSeek(cols, descs, vals))
+		}
+		// 多读一条用于判断是否还有更多数据
+		rows, err := q.OrderBy(opts.PageOrder...).Limit(size + 1).Query(ctx).AllMap()
+		if err != nil {
+			return page, err
+		}
+		if int64(len(rows)) > size {
+			rows = rows[:size]
+			page.HasMore = true
+			page.NextCursor, err = sealEncodeCursor(rows[len(rows)-1], cols)
+			if err != nil {
+				return page, err
+			}
+		}
+		return page, utils.Map2Struct(rows, data)
+	}
+}
+
+// sealPageOrder 解析排序字段及方向
+func sealPageOrder(order []string) ([]string, []bool) {
+	cols := make([]string, 0, len(order))
+	descs := make([]bool, 0, len(order))
+	for _, o := range order {
+		col, desc := o, false
+		if fields := strings.Fields(o); len(fields) == 2 {
+			col, desc = fields[0], strings.EqualFold(fields[1], "DESC")
+		}
+		cols = append(cols, col)
+		descs = append(descs, desc)
+	}
+	return cols, descs
+}
+
+// sealSeek 游标条件，(a, b) > (x, y) 展开为 a > x OR (a = x AND b > y)
+func sealSeek(cols []string, descs []bool, vals []interface{}) expr.Expr {
+	ors := make([]expr.Expr, 0, len(cols))
+	for i := range cols {
+		op := ">"
+		if descs[i] {
+			op = "<"
+		}
+		ands := make([]expr.Expr, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, seal.Eq(cols[j], vals[j]))
+		}
+		ands = append(ands, seal.Op(cols[i], op, vals[i]))
+		if len(ands) == 1 {
+			ors = append(ors, ands[0])
+		} else {
+			ors = append(ors, seal.And(ands...))
+		}
+	}
+	if len(ors) == 1 {
+		return ors[0]
+	}
+	return seal.Or(ors...)
+}
+
+// sealEncodeCursor 将行的排序字段值编码为游标
+func sealEncodeCursor(row map[string]interface{}, cols []string) (string, error) {
+	vals := make([]interface{}, 0, len(cols))
+	for _, c := range cols {
+		v, ok := row[c]
+		if !ok {
+			return "", ErrPageColumnMissing
+		}
+		switch tv := v.(type) {
+		case nil:
+			// col > NULL不匹配任何行，无法继续翻页
+			return "", ErrPageNullValue
+		case []byte:
+			v = string(tv)
+		case time.Time:
+			// 时间单独标记，解码时还原为time.Time，避免与字符串字段混淆
+			v = pageCursorTime{T: tv.Format(time.RFC3339Nano)}
+		}
+		vals = append(vals, v)
+	}
+	buf, err := json.Marshal(vals)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sealDecodeCursor 解码游标
+func sealDecodeCursor(cursor string, n int) ([]interface{}, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrPageCursorInvalid
+	}
+	var vals []interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&vals); err != nil || len(vals) != n {
+		return nil, ErrPageCursorInvalid
+	}
+	for i, v := range vals {
+		switch tv := v.(type) {
+		case json.Number:
+			if iv, err := tv.Int64(); err == nil {
+				vals[i] = iv
+			} else if fv, err := tv.Float64(); err == nil {
+				vals[i] = fv
+			}
+		case map[string]interface{}:
+			str, ok := tv["t"].(string)
+			if !ok {
+				return nil, ErrPageCursorInvalid
+			}
+			t, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return nil, ErrPageCursorInvalid
+			}
+			vals[i] = t
+		case nil:
+			return nil, ErrPageCursorInvalid
+		}
+	}
+	return vals, nil
+}
+
+// pageCursorTime 游标中的时间值，RFC3339Nano格式保留时区及纳秒
+type pageCursorTime struct {
+	T string `json:"t"`
+}
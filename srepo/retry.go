@@ -0,0 +1,260 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrCircuitOpen error = serr.New(serr.ErrUnavailable, "circuit breaker is open")
+
+// DefaultRetryMaxSpan 重试间隔上限
+var DefaultRetryMaxSpan time.Duration = time.Second
+
+// DefaultBreakerFailThreshold 连续失败多少次后熔断
+var DefaultBreakerFailThreshold int32 = 5
+
+// DefaultBreakerOpenTimeout 熔断持续时间
+var DefaultBreakerOpenTimeout time.Duration = time.Second * 5
+
+// MySQL错误码
+const (
+	mysqlErrTooManyConnections = 1040
+	mysqlErrLockWaitTimeout    = 1205
+	mysqlErrDeadlock           = 1213
+	mysqlErrServerGone         = 2006
+	mysqlErrServerLost         = 2013
+)
+
+// IsRetryable 是否为可重试的错误：连接失效、死锁、锁等待超时、连接数过多
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	code, ok := serr.MysqlCode(err)
+	if !ok {
+		return false
+	}
+	switch code {
+	case mysqlErrDeadlock, mysqlErrLockWaitTimeout, mysqlErrTooManyConnections, mysqlErrServerGone, mysqlErrServerLost:
+		return true
+	}
+	return false
+}
+
+// IsUnavailable 是否为数据库不可用的错误：连接失败、网络错误、超时、服务关闭，调用方取消不计入
+func IsUnavailable(err error) bool {
+	kind := serr.Kind(err)
+	return kind == serr.ErrUnavailable || kind == serr.ErrTimeout
+}
+
+// sealRetry 执行fn，返回可重试的错误时按指数退避重试，times为最大重试次数
+func sealRetry(ctx context.Context, times int, span time.Duration, retryFn func(err error) bool, fn func() error) error {
+	if retryFn == nil {
+		retryFn = IsRetryable
+	}
+	err := fn()
+	for i := 0; i < times && err != nil && retryFn(err); i++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(span):
+		}
+		span *= 2
+		if span > DefaultRetryMaxSpan {
+			span = DefaultRetryMaxSpan
+		}
+		err = fn()
+	}
+	return err
+}
+
+// sealTimeout 操作超时，未配置WithTimeout时不设置
+func (opts RepoSealOptions) sealTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if opts.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, opts.Timeout)
+}
+
+// sealGuard 为执行对象添加错误分类、重试及熔断，驱动错误通过serr.From分类
+// 事务中的执行对象不重试，由WithTxRetry重试整个事务
+func (opts RepoSealOptions) sealGuard(q query.Query, op sealOp, inTx bool) query.Query {
+	// 执行日志由内层查询对象输出，每次重试均记录
+	sopts := *q.Options()
+	sopts.ExecLog = nil
+	return query.NewQuery(q.Builder(), guardExecutor{e: queryExecutor{q: q}, opts: opts, op: op, inTx: inTx}, &sopts)
+}
+
+// guardExecutor 错误分类、重试及熔断执行器，读取总是重试，写入仅在WithIdempotent时重试
+// 写入操作中的查询同样按写入处理，如PostgreSQL的INSERT ... RETURNING
+type guardExecutor struct {
+	e    query.Executor
+	opts RepoSealOptions
+	op   sealOp
+	inTx bool
+}
+
+func (e guardExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.ExecContext(context.Background(), sql, args...)
+}
+
+func (e guardExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (res sql.Result, err error) {
+	err = e.do(ctx, e.opts.Idempotent, func() error {
+		var err error
+		res, err = e.e.ExecContext(ctx, sql, args...)
+		return err
+	})
+	return res, err
+}
+
+func (e guardExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), sql, args...)
+}
+
+func (e guardExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (rows *sql.Rows, err error) {
+	err = e.do(ctx, e.op == sealOpRead || e.opts.Idempotent, func() error {
+		var err error
+		rows, err = e.e.QueryContext(ctx, sql, args...)
+		return err
+	})
+	return rows, err
+}
+
+// do 经熔断器执行fn，retry为true且不在事务中时按配置重试
+func (e guardExecutor) do(ctx context.Context, retry bool, fn func() error) error {
+	if e.inTx {
+		return serr.From(fn())
+	}
+	times := 0
+	if retry {
+		times = e.opts.RetryTimes
+	}
+	err := sealRetry(ctx, times, e.opts.RetrySpan, e.opts.RetryFn, func() error {
+		b := e.opts.Breaker
+		if b == nil {
+			return fn()
+		}
+		if !b.allow() {
+			return ErrCircuitOpen
+		}
+		err := fn()
+		b.report(err)
+		return err
+	})
+	return serr.From(err)
+}
+
+// BreakerState 熔断器状态
+type BreakerState int8
+
+const (
+	BreakerClosed   BreakerState = 0 // 正常
+	BreakerOpen     BreakerState = 1 // 熔断，直接返回ErrCircuitOpen
+	BreakerHalfOpen BreakerState = 2 // 熔断到期，放行一个探测请求
+)
+
+// BreakerOptions 熔断配置
+type BreakerOptions struct {
+	FailThreshold int32
+	OpenTimeout   time.Duration
+	FailureFn     func(err error) bool // 判断错误是否计入失败次数
+}
+
+// BreakerOptionHandler 熔断配置选项
+type BreakerOptionHandler func(*BreakerOptions)
+
+// DefaultBreakerOptions 创建默认的熔断配置，数据库不可用的错误计入失败
+func DefaultBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		FailThreshold: DefaultBreakerFailThreshold,
+		OpenTimeout:   DefaultBreakerOpenTimeout,
+		FailureFn:     IsUnavailable,
+	}
+}
+
+// WithBreakerThreshold 连续失败threshold次后熔断，openTimeout后放行一个探测请求，成功时恢复
+func WithBreakerThreshold(threshold int32, openTimeout time.Duration) BreakerOptionHandler {
+	return func(opts *BreakerOptions) {
+		opts.FailThreshold = threshold
+		opts.OpenTimeout = openTimeout
+	}
+}
+
+// WithBreakerFailureFn 判断错误是否计入失败次数
+func WithBreakerFailureFn(fn func(err error) bool) BreakerOptionHandler {
+	return func(opts *BreakerOptions) {
+		opts.FailureFn = fn
+	}
+}
+
+// Breaker 熔断器，每个数据库实例创建一个，使用该实例的Repo通过WithBreaker共享
+type Breaker struct {
+	opts     BreakerOptions
+	mu       sync.Mutex
+	state    BreakerState
+	fails    int32
+	openedAt time.Time
+}
+
+// NewBreaker 创建熔断器
+func NewBreaker(hands ...BreakerOptionHandler) *Breaker {
+	// 默认配置
+	opts := DefaultBreakerOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return &Breaker{opts: opts}
+}
+
+// State 当前状态，熔断到期但未放行探测请求时仍为BreakerOpen
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow 是否放行请求
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.opts.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// 探测请求执行中
+		return false
+	}
+	return true
+}
+
+// report 上报执行结果
+func (b *Breaker) report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !b.opts.FailureFn(err) {
+		b.state = BreakerClosed
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.state == BreakerHalfOpen || b.fails >= b.opts.FailThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,250 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/query"
+)
+
+// RouterBalance 从库负载均衡方式
+type RouterBalance int8
+
+const (
+	RouterBalanceRoundRobin RouterBalance = 1 // 轮询
+	RouterBalanceWeighted   RouterBalance = 2 // 按权重平滑轮询
+)
+
+// DefaultRouterFailThreshold 从库连续失败多少次后标记为不可用
+var DefaultRouterFailThreshold int32 = 3
+
+// DefaultRouterCooldown 从库不可用的持续时间，到期后重新参与负载均衡
+var DefaultRouterCooldown time.Duration = time.Second * 10
+
+// RouterFailureFn 判断错误是否计入从库失败次数
+type RouterFailureFn func(err error) bool
+
+// RouterOptions 读写分离配置
+type RouterOptions struct {
+	Balance       RouterBalance
+	FailThreshold int32
+	Cooldown      time.Duration
+	FailureFn     RouterFailureFn
+}
+
+// RouterOptionHandler 读写分离配置选项
+type RouterOptionHandler func(*RouterOptions)
+
+// DefaultRouterOptions 创建默认的读写分离配置
+// 默认轮询，context取消、超时及无数据不计入失败
+func DefaultRouterOptions() RouterOptions {
+	return RouterOptions{
+		Balance:       RouterBalanceRoundRobin,
+		FailThreshold: DefaultRouterFailThreshold,
+		Cooldown:      DefaultRouterCooldown,
+		FailureFn: func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, sql.ErrNoRows)
+		},
+	}
+}
+
+// WithRouterBalance 从库负载均衡方式
+func WithRouterBalance(b RouterBalance) RouterOptionHandler {
+	return func(opts *RouterOptions) {
+		opts.Balance = b
+	}
+}
+
+// WithRouterUnhealthy 从库连续失败threshold次后，cooldown时间内不再路由到该从库
+func WithRouterUnhealthy(threshold int32, cooldown time.Duration) RouterOptionHandler {
+	return func(opts *RouterOptions) {
+		opts.FailThreshold = threshold
+		opts.Cooldown = cooldown
+	}
+}
+
+// WithRouterFailureFn 判断错误是否计入从库失败次数
+func WithRouterFailureFn(fn RouterFailureFn) RouterOptionHandler {
+	return func(opts *RouterOptions) {
+		opts.FailureFn = fn
+	}
+}
+
+// replica 从库
+type replica struct {
+	q         query.Query
+	weight    int
+	current   int // 平滑轮询的当前权重
+	fails     int32
+	downUntil int64 // 不可用截止时间，UnixNano
+}
+
+// Router 读写分离路由
+// 写操作使用主库，读操作按负载均衡方式使用健康的从库，没有可用从库时使用主库
+type Router struct {
+	opts     RouterOptions
+	primary  seal.DB
+	replicas []*replica
+	counter  uint64
+	mu       sync.Mutex
+}
+
+// NewRouter 创建读写分离路由
+func NewRouter(primary seal.DB, hands ...RouterOptionHandler) *Router {
+	// 默认配置
+	opts := DefaultRouterOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return &Router{
+		opts:    opts,
+		primary: primary,
+	}
+}
+
+// AddReplica 注册从库，weight仅在按权重轮询时生效，小于1时按1处理
+func (r *Router) AddReplica(db seal.DB, weight int) *Router {
+	if weight < 1 {
+		weight = 1
+	}
+	rep := &replica{weight: weight}
+	// 执行日志由内层查询对象输出，避免重复
+	sopts := *db.Options()
+	sopts.ExecLog = nil
+	rep.q = query.NewQuery(db.Builder(), routeExecutor{q: db.Query, report: func(err error) { r.report(rep, err) }}, &sopts)
+	r.mu.Lock()
+	r.replicas = append(r.replicas, rep)
+	r.mu.Unlock()
+	return r
+}
+
+// Primary 主库
+func (r *Router) Primary() seal.DB {
+	return r.primary
+}
+
+// Write 获取写操作的执行对象
+func (r *Router) Write(ctx context.Context) query.Query {
+	if st, ok := ctx.Value(readYourWritesKey{}).(*readYourWrites); ok {
+		atomic.StoreInt32(&st.written, 1)
+	}
+	return r.primary.Query
+}
+
+// Read 获取读操作的执行对象
+// context通过WithReadYourWrites开启且请求中已有写操作时使用主库
+func (r *Router) Read(ctx context.Context) query.Query {
+	if st, ok := ctx.Value(readYourWritesKey{}).(*readYourWrites); ok && atomic.LoadInt32(&st.written) == 1 {
+		return r.primary.Query
+	}
+	rep := r.pick()
+	if rep == nil {
+		return r.primary.Query
+	}
+	return rep.q
+}
+
+// pick 选取健康的从库
+func (r *Router) pick() *replica {
+	now := time.Now().UnixNano()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	healthy := make([]*replica, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		if atomic.LoadInt64(&rep.downUntil) <= now {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if r.opts.Balance != RouterBalanceWeighted {
+		rep := healthy[r.counter%uint64(len(healthy))]
+		r.counter++
+		return rep
+	}
+	// 平滑加权轮询
+	total := 0
+	var best *replica
+	for _, rep := range healthy {
+		rep.current += rep.weight
+		total += rep.weight
+		if best == nil || rep.current > best.current {
+			best = rep
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// report 记录从库执行结果，连续失败达到阈值后标记为不可用
+func (r *Router) report(rep *replica, err error) {
+	if err == nil || !r.opts.FailureFn(err) {
+		atomic.StoreInt32(&rep.fails, 0)
+		return
+	}
+	if atomic.AddInt32(&rep.fails, 1) >= r.opts.FailThreshold {
+		atomic.StoreInt32(&rep.fails, 0)
+		atomic.StoreInt64(&rep.downUntil, time.Now().Add(r.opts.Cooldown).UnixNano())
+	}
+}
+
+// routeExecutor 记录从库执行结果的执行器
+type routeExecutor struct {
+	q      query.Query
+	report func(err error)
+}
+
+func (e routeExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.ExecContext(context.Background(), sql, args...)
+}
+
+func (e routeExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
+	res := e.q.ExecContext(ctx, sql, args...)
+	// query.Result在执行失败时各方法均返回执行错误
+	_, err := res.RowsAffected()
+	e.report(err)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e routeExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), sql, args...)
+}
+
+func (e routeExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
+	rows := e.q.QueryContext(ctx, sql, args...)
+	if rows.Rows == nil {
+		// 查询失败，AllMap直接返回查询错误
+		_, err := rows.AllMap()
+		e.report(err)
+		return nil, err
+	}
+	e.report(nil)
+	return rows.Rows, nil
+}
+
+// readYourWritesKey 读己之写标记在context中的KEY
+type readYourWritesKey struct{}
+
+// readYourWrites 读己之写状态
+type readYourWrites struct {
+	written int32
+}
+
+// WithReadYourWrites 开启读己之写，通常在请求入口调用
+// 返回的context中发生写操作后，后续读操作均路由到主库
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(readYourWritesKey{}).(*readYourWrites); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, readYourWritesKey{}, &readYourWrites{})
+}
@@ -1,94 +1,211 @@
 package srepo
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
 	"github.com/rumis/seal"
+	"github.com/rumis/seal/expr"
 	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/serr"
 )
 
-// SealQEq 相等
+// 错误定义
+var ErrClauseUnsupported error = serr.New(serr.ErrInvalid, "clause handler does not support this query")
+var ErrClauseEmpty error = serr.New(serr.ErrInvalid, "clause conditions is empty")
+
+// SealEq 相等，适用于查询、更新及删除
+func SealEq(key string, val interface{}) ClauseHandler {
+	return sealWhereHandler(seal.Eq(key, val))
+}
+
+// SealIn    IN，适用于查询、更新及删除
+func SealIn(key string, val ...interface{}) ClauseHandler {
+	return sealWhereHandler(seal.In(key, val...))
+}
+
+// SealLike 模糊查询，适用于查询、更新及删除
+func SealLike(key string, val string) ClauseHandler {
+	return sealWhereHandler(seal.Like(key, val))
+}
+
+// SealOp 一般操作符 > < >= <= 等，适用于查询、更新及删除
+func SealOp(key string, op string, val interface{}) ClauseHandler {
+	return sealWhereHandler(seal.Op(key, op, val))
+}
+
+// SealQEq 相等，同SealEq
 func SealQEq(key string, val interface{}) ClauseHandler {
-	return func(q interface{}) {
-		sq, ok := q.(*query.SelectQuery)
-		if !ok {
-			return
-		}
-		sq.Where(seal.Eq(key, val))
-	}
+	return SealEq(key, val)
 }
 
-// SealQIn    IN
+// SealQIn    IN，同SealIn
 func SealQIn(key string, val ...interface{}) ClauseHandler {
-	return func(q interface{}) {
+	return SealIn(key, val...)
+}
+
+// SealQLike 模糊查询，同SealLike
+func SealQLike(key string, val string) ClauseHandler {
+	return SealLike(key, val)
+}
+
+// SealQOp 一般操作符 > < >= <= 等，同SealOp
+func SealQOp(key string, op string, val interface{}) ClauseHandler {
+	return SealOp(key, op, val)
+}
+
+// SealQOrderBy 排序，如【c1 DESC】，仅适用于查询
+func SealQOrderBy(cols ...string) ClauseHandler {
+	return func(q interface{}) error {
 		sq, ok := q.(*query.SelectQuery)
 		if !ok {
-			return
+			return ErrClauseUnsupported
 		}
-		sq.Where(seal.In(key, val...))
+		sq.OrderBy(cols...)
+		return nil
 	}
 }
 
-// SealQLike 模糊查询
-func SealQLike(key string, val string) ClauseHandler {
-	return func(q interface{}) {
+// SealQLimit 读取条数，仅适用于查询
+func SealQLimit(limit int64) ClauseHandler {
+	return func(q interface{}) error {
 		sq, ok := q.(*query.SelectQuery)
 		if !ok {
-			return
+			return ErrClauseUnsupported
 		}
-		sq.Where(seal.Like(key, val))
+		sq.Limit(limit)
+		return nil
 	}
 }
 
-// SealQOp 一般操作符 > < >= <= 等
-func SealQOp(key string, op string, val interface{}) ClauseHandler {
-	return func(q interface{}) {
+// SealQOffset 偏移量，仅适用于查询，数据量大时建议使用NewSealMysqlPager
+func SealQOffset(offset int64) ClauseHandler {
+	return func(q interface{}) error {
 		sq, ok := q.(*query.SelectQuery)
 		if !ok {
-			return
+			return ErrClauseUnsupported
 		}
-		sq.Where(seal.Op(key, op, val))
+		sq.Offset(offset)
+		return nil
 	}
 }
 
-// SealUEq 相等
+// SealUEq 相等，同SealEq
 func SealUEq(key string, val interface{}) ClauseHandler {
-	return func(q interface{}) {
-		uq, ok := q.(*query.UpdateQuery)
-		if !ok {
-			return
-		}
-		uq.Where(seal.Eq(key, val))
-	}
+	return SealEq(key, val)
 }
 
-// SealUIn    IN
+// SealUIn    IN，同SealIn
 func SealUIn(key string, val ...interface{}) ClauseHandler {
-	return func(q interface{}) {
-		uq, ok := q.(*query.UpdateQuery)
-		if !ok {
-			return
-		}
-		uq.Where(seal.In(key, val...))
-	}
+	return SealIn(key, val...)
 }
 
-// SealULike 模糊查询
+// SealULike 模糊查询，同SealLike
 func SealULike(key string, val string) ClauseHandler {
-	return func(q interface{}) {
-		uq, ok := q.(*query.UpdateQuery)
-		if !ok {
-			return
-		}
-		uq.Where(seal.Like(key, val))
-	}
+	return SealLike(key, val)
 }
 
-// SealUOp 一般操作符 > < >= <= 等
+// SealUOp 一般操作符 > < >= <= 等，同SealOp
 func SealUOp(key string, op string, val interface{}) ClauseHandler {
-	return func(q interface{}) {
-		uq, ok := q.(*query.UpdateQuery)
-		if !ok {
-			return
+	return SealOp(key, op, val)
+}
+
+// SealDEq 相等，同SealEq
+func SealDEq(key string, val interface{}) ClauseHandler {
+	return SealEq(key, val)
+}
+
+// SealDIn    IN，同SealIn
+func SealDIn(key string, val ...interface{}) ClauseHandler {
+	return SealIn(key, val...)
+}
+
+// SealDLike 模糊查询，同SealLike
+func SealDLike(key string, val string) ClauseHandler {
+	return SealLike(key, val)
+}
+
+// SealDOp 一般操作符 > < >= <= 等，同SealOp
+func SealDOp(key string, op string, val interface{}) ClauseHandler {
+	return SealOp(key, op, val)
+}
+
+// sealWhereHandler 条件子句
+func sealWhereHandler(e expr.Expr) ClauseHandler {
+	return func(q interface{}) error {
+		return sealWhere(q, e)
+	}
+}
+
+// sealWhere 为查询、更新及删除添加条件
+// 软删除模式下删除器使用UpdateQuery，同样生效
+func sealWhere(q interface{}, e expr.Expr) error {
+	switch sq := q.(type) {
+	case *query.SelectQuery:
+		sq.Where(e)
+	case *query.UpdateQuery:
+		sq.Where(e)
+	case *query.DeleteQuery:
+		sq.Where(e)
+	default:
+		return ErrClauseUnsupported
+	}
+	return nil
+}
+
+// sealHasWhere 条件子句是否生成了WHERE条件，如空的CondAnd()不生成条件
+// seal无法读取已设置的条件，将子句应用于同类型的探测语句，由probeExecutor获取生成的SQL，不访问数据库
+// update为true时探测更新语句，软删除的删除器同样使用更新语句
+func sealHasWhere(ctx context.Context, sq query.Query, update bool, handler []ClauseHandler) (bool, error) {
+	if len(handler) == 0 {
+		return false, nil
+	}
+	var built string
+	sopts := *sq.Options()
+	sopts.BuildLog = nil
+	sopts.ExecLog = nil
+	pq := query.NewQuery(sq.Builder(), probeExecutor{sql: &built}, &sopts)
+	var q interface{} = pq.Delete("t")
+	if update {
+		q = pq.Update("t")
+	}
+	for _, v := range handler {
+		err := v(q)
+		if err != nil {
+			return false, err
 		}
-		uq.Where(seal.Op(key, op, val))
 	}
+	var cnt int64
+	if uq, ok := q.(*query.UpdateQuery); ok {
+		// 未设置条件时seal构建失败，built为空
+		uq.Value(map[string]interface{}{"t": 0}).Exec(ctx, &cnt)
+	} else {
+		q.(*query.DeleteQuery).Exec(ctx, &cnt)
+	}
+	return strings.Contains(built, " WHERE "), nil
+}
+
+// probeExecutor 记录SQL而不执行
+type probeExecutor struct {
+	sql *string
+}
+
+func (e probeExecutor) Exec(sql string, args ...interface{}) (sql.Result, error) {
+	return e.ExecContext(context.Background(), sql, args...)
+}
+
+func (e probeExecutor) ExecContext(ctx context.Context, sql string, args ...interface{}) (sql.Result, error) {
+	*e.sql = sql
+	return driver.RowsAffected(0), nil
+}
+
+func (e probeExecutor) Query(sql string, args ...interface{}) (*sql.Rows, error) {
+	return e.QueryContext(context.Background(), sql, args...)
+}
+
+func (e probeExecutor) QueryContext(ctx context.Context, sql string, args ...interface{}) (*sql.Rows, error) {
+	*e.sql = sql
+	return nil, ErrClauseUnsupported
 }
@@ -0,0 +1,159 @@
+package srepo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/expr"
+	"github.com/rumis/seal/query"
+)
+
+// CondEq 相等
+func CondEq(key string, val interface{}) expr.Expr {
+	return seal.Eq(key, val)
+}
+
+// CondOp 一般操作符 > < >= <= != 等
+func CondOp(key string, op string, val interface{}) expr.Expr {
+	return seal.Op(key, op, val)
+}
+
+// CondIn    IN
+func CondIn(key string, val ...interface{}) expr.Expr {
+	return seal.In(key, val...)
+}
+
+// CondNotIn    NOT IN
+func CondNotIn(key string, val ...interface{}) expr.Expr {
+	return seal.NotIn(key, val...)
+}
+
+// CondLike 模糊查询
+func CondLike(key string, val string) expr.Expr {
+	return seal.Like(key, val)
+}
+
+// CondNotLike 模糊查询取反
+func CondNotLike(key string, val string) expr.Expr {
+	return seal.NotLike(key, val)
+}
+
+// CondIsNull    IS NULL
+func CondIsNull(key string) expr.Expr {
+	return expr.New(key + " IS NULL")
+}
+
+// CondNotNull    IS NOT NULL
+func CondNotNull(key string) expr.Expr {
+	return expr.New(key + " IS NOT NULL")
+}
+
+// CondBetween    BETWEEN
+func CondBetween(key string, from, to interface{}) expr.Expr {
+	return seal.Between(key, from, to)
+}
+
+// CondNotBetween    NOT BETWEEN
+func CondNotBetween(key string, from, to interface{}) expr.Expr {
+	return seal.NotBetween(key, from, to)
+}
+
+// CondAnd 以AND组合多个条件，结果带括号
+func CondAnd(conds ...expr.Expr) expr.Expr {
+	return seal.And(conds...)
+}
+
+// CondOr 以OR组合多个条件，结果带括号
+func CondOr(conds ...expr.Expr) expr.Expr {
+	return seal.Or(conds...)
+}
+
+// CondNot 条件取反
+func CondNot(cond expr.Expr) expr.Expr {
+	return seal.Not(cond)
+}
+
+// CondRaw 原生条件，使用?作为参数占位符，如【CondRaw("score > ? + bonus", 60)】
+func CondRaw(sql string, args ...interface{}) expr.Expr {
+	return rawExp{sql: sql, args: args}
+}
+
+// rawExp 原生条件
+type rawExp struct {
+	sql  string
+	args []interface{}
+}
+
+// Build 将?替换为seal的命名参数
+func (e rawExp) Build(params expr.Params) string {
+	if len(e.args) == 0 {
+		return e.sql
+	}
+	var b strings.Builder
+	i := 0
+	for _, c := range e.sql {
+		if c != '?' || i >= len(e.args) {
+			b.WriteRune(c)
+			continue
+		}
+		p := fmt.Sprintf("p%v", len(params))
+		params[p] = e.args[i]
+		i++
+		b.WriteString("{:" + p + "}")
+	}
+	return b.String()
+}
+
+// SealWhere 条件，适用于查询、更新及删除，多个条件以AND连接
+func SealWhere(conds ...expr.Expr) ClauseHandler {
+	return func(q interface{}) error {
+		if len(conds) == 0 {
+			return ErrClauseEmpty
+		}
+		for _, c := range conds {
+			err := sealWhere(q, c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SealGroupBy 分组，仅适用于查询
+func SealGroupBy(cols ...string) ClauseHandler {
+	return func(q interface{}) error {
+		sq, ok := q.(*query.SelectQuery)
+		if !ok {
+			return ErrClauseUnsupported
+		}
+		sq.GroupBy(cols...)
+		return nil
+	}
+}
+
+// SealHaving 分组过滤条件，多个条件以AND连接，仅适用于查询
+func SealHaving(conds ...expr.Expr) ClauseHandler {
+	return func(q interface{}) error {
+		sq, ok := q.(*query.SelectQuery)
+		if !ok {
+			return ErrClauseUnsupported
+		}
+		if len(conds) == 0 {
+			return ErrClauseEmpty
+		}
+		for _, c := range conds {
+			sq.Having(c)
+		}
+		return nil
+	}
+}
+
+// sealFrom 表名及关联表
+func sealFrom(opts RepoSealOptions) string {
+	if len(opts.Joins) == 0 {
+		return opts.Name
+	}
+	return opts.Name + " " + strings.Join(opts.Joins, " ")
+}
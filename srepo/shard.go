@@ -0,0 +1,310 @@
+package srepo
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rumis/seal/query"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrShardKeyNil error = serr.New(serr.ErrInvalid, "shard key is nil")
+var ErrShardKeyInvalid error = serr.New(serr.ErrInvalid, "shard key must be integer or string")
+var ErrShardingNil error = serr.New(serr.ErrInvalid, "sharding is nil")
+var ErrShardTablesInvalid error = serr.New(serr.ErrInvalid, "shard tables must be positive")
+
+// Shard 分片位置
+type Shard struct {
+	Inst   string // SetSealDB注册的实例名，为空时使用WithDB/WithRouter
+	Suffix string // 表名后缀
+}
+
+// ShardFunc 分片函数，根据分片键计算分片位置
+type ShardFunc func(key interface{}) (Shard, error)
+
+// Sharding 分片配置
+type Sharding struct {
+	Fn     ShardFunc
+	Shards []Shard // 全部分片，用于跨分片读取
+}
+
+// NewModSharding 按分片键取模分表，表后缀为_00.._{tables-1}，各表按顺序均匀分布到insts实例上
+// 整数分片键直接取模，字符串分片键先计算crc32，tables不大于0时读写返回ErrShardTablesInvalid
+func NewModSharding(tables int, insts ...string) Sharding {
+	if tables <= 0 {
+		return Sharding{
+			Fn: func(key interface{}) (Shard, error) {
+				return Shard{}, ErrShardTablesInvalid
+			},
+		}
+	}
+	shardAt := func(idx int) Shard {
+		s := Shard{Suffix: fmt.Sprintf("_%02d", idx)}
+		if len(insts) > 0 {
+			s.Inst = insts[idx*len(insts)/tables]
+		}
+		return s
+	}
+	shards := make([]Shard, 0, tables)
+	for i := 0; i < tables; i++ {
+		shards = append(shards, shardAt(i))
+	}
+	return Sharding{
+		Fn: func(key interface{}) (Shard, error) {
+			var n uint64
+			v := reflect.ValueOf(key)
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				if v.Int() < 0 {
+					return Shard{}, ErrShardKeyInvalid
+				}
+				n = uint64(v.Int())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				n = v.Uint()
+			case reflect.String:
+				n = uint64(crc32.ChecksumIEEE([]byte(v.String())))
+			default:
+				return Shard{}, ErrShardKeyInvalid
+			}
+			return shardAt(int(n % uint64(tables))), nil
+		},
+		Shards: shards,
+	}
+}
+
+// shardKeyContextKey 分片键在context中的KEY
+type shardKeyContextKey struct{}
+
+// WithShardKey 设置分片键，配置WithSharding的读写对象根据分片键确定表及数据库
+func WithShardKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, shardKeyContextKey{}, key)
+}
+
+// ShardKeyFromContext 获取context中的分片键
+func ShardKeyFromContext(ctx context.Context) (interface{}, bool) {
+	key := ctx.Value(shardKeyContextKey{})
+	return key, key != nil
+}
+
+// sealShard 根据context中的分片键确定表名及数据库
+func (opts RepoSealOptions) sealShard(ctx context.Context) (RepoSealOptions, error) {
+	if opts.Err != nil {
+		return opts, opts.Err
+	}
+	if opts.Sharding == nil {
+		return opts, nil
+	}
+	key, ok := ShardKeyFromContext(ctx)
+	if !ok {
+		return opts, ErrShardKeyNil
+	}
+	shard, err := opts.Sharding.Fn(key)
+	if err != nil {
+		return opts, err
+	}
+	return opts.sealOnShard(shard)
+}
+
+// sealOnShard 指定分片的配置
+func (opts RepoSealOptions) sealOnShard(shard Shard) (RepoSealOptions, error) {
+	opts.Name += shard.Suffix
+	if shard.Inst != "" {
+		db, err := GetSealDB(shard.Inst)
+		if err != nil {
+			return opts, err
+		}
+		opts.DB = db
+		opts.Router = nil
+	}
+	return opts, nil
+}
+
+// sealResolve 确定本次操作的配置及执行对象
+func (opts RepoSealOptions) sealResolve(ctx context.Context, op sealOp) (RepoSealOptions, query.Query, error) {
+	opts, err := opts.sealShard(ctx)
+	if err != nil {
+		return opts, query.Query{}, err
+	}
+	sq, err := opts.sealQuery(ctx, op)
+	return opts, sq, err
+}
+
+// NewSealMysqlScatterReader 创建新的Seal跨分片数据读取对象
+// 并发查询全部分片后合并，按WithScatterOrder排序，按WithScatterLimit截取
+func NewSealMysqlScatterReader(hands ...RepoSealOptionHandler) RepoReader {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
+		if opts.Sharding == nil {
+			return ErrShardingNil
+		}
+		if len(opts.Sharding.Shards) == 0 {
+			return ErrShardTablesInvalid
+		}
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		results := make([][]map[string]interface{}, len(opts.Sharding.Shards))
+		errs := make([]error, len(opts.Sharding.Shards))
+		var wg sync.WaitGroup
+		for i, shard := range opts.Sharding.Shards {
+			wg.Add(1)
+			go func(i int, shard Shard) {
+				defer wg.Done()
+				results[i], errs[i] = sealScatterOne(ctx, opts, shard, handler)
+			}(i, shard)
+		}
+		wg.Wait()
+		var rows []map[string]interface{}
+		for i, err := range errs {
+			if err != nil {
+				return err
+			}
+			rows = append(rows, results[i]...)
+		}
+		if len(opts.ScatterOrder) > 0 {
+			sort.SliceStable(rows, func(i, j int) bool {
+				return sealRowLess(rows[i], rows[j], opts.ScatterOrder)
+			})
+		}
+		if opts.ScatterOffset > 0 {
+			if opts.ScatterOffset >= int64(len(rows)) {
+				rows = rows[:0]
+			} else {
+				rows = rows[opts.ScatterOffset:]
+			}
+		}
+		if opts.ScatterLimit > 0 && opts.ScatterLimit < int64(len(rows)) {
+			rows = rows[:opts.ScatterLimit]
+		}
+		return utils.Map2Struct(rows, data)
+	}
+}
+
+// sealScatterOne 查询单个分片
+func sealScatterOne(ctx context.Context, opts RepoSealOptions, shard Shard, handler []ClauseHandler) ([]map[string]interface{}, error) {
+	opts, err := opts.sealOnShard(shard)
+	if err != nil {
+		return nil, err
+	}
+	sq, err := opts.sealQuery(ctx, sealOpRead)
+	if err != nil {
+		return nil, err
+	}
+	q := sq.Select(opts.Columns...).From(sealFrom(opts))
+	for _, v := range handler {
+		err = v(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sealSoftDeleteFilter(opts, q)
+	if len(opts.ScatterOrder) > 0 {
+		q.OrderBy(opts.ScatterOrder...)
+	}
+	// 各分片需返回offset+limit条数据，合并后才能得到正确的结果
+	if opts.ScatterLimit > 0 {
+		q.Limit(opts.ScatterOffset + opts.ScatterLimit)
+	}
+	return q.Query(ctx).AllMap()
+}
+
+// sealRowLess 按排序字段比较两行数据，字段格式同OrderBy，如【c1 DESC】
+func sealRowLess(a, b map[string]interface{}, order []string) bool {
+	for _, o := range order {
+		col, desc := o, false
+		if fields := strings.Fields(o); len(fields) == 2 {
+			col, desc = fields[0], strings.EqualFold(fields[1], "DESC")
+		}
+		c := sealCompare(a[col], b[col])
+		if c == 0 {
+			continue
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// sealCompare 比较数据库返回的值，NULL最小
+// 数值及可解析为数值的字符串、[]byte按数值比较(MySQL文本协议下DECIMAL、整数以[]byte返回)，
+// 其他字符串按字节比较，与各分片的排序规则可能不同，排序字段宜使用数值或时间类型
+func sealCompare(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if ab, ok := a.([]byte); ok {
+		a = string(ab)
+	}
+	if bb, ok := b.([]byte); ok {
+		b = string(bb)
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			}
+			return 0
+		}
+	}
+	// 双方均为数值时按数值比较，如DECIMAL返回的[]byte("9")小于[]byte("10")
+	af, aok := sealFloat(a)
+	bf, bok := sealFloat(b)
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// sealFloat 数值或数值字符串转换为float64
+func sealFloat(val interface{}) (float64, bool) {
+	if str, ok := val.(string); ok {
+		f, err := strconv.ParseFloat(str, 64)
+		return f, err == nil
+	}
+	return sealNumber(val)
+}
+
+// sealNumber 数值类型转换为float64
+func sealNumber(val interface{}) (float64, bool) {
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
@@ -2,21 +2,77 @@ package srepo
 
 import (
 	"context"
-	"errors"
+	"time"
 
-	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/serr"
 )
 
 // 错误定义
-var ErrBothDbAndTxNil error = errors.New("both db and tx is nil")
-var ErrUpdateAffectZeroRows error = errors.New("update clauses affect zero rows")
+var ErrBothDbAndTxNil error = serr.New(serr.ErrInvalid, "both db and tx is nil")
+var ErrUpdateAffectZeroRows error = serr.New(serr.ErrNotFound, "update clauses affect zero rows")
+var ErrFenceTokenRejected error = serr.New(serr.ErrConflict, "update rejected by fencing token")
+var ErrDeleteWithoutWhere error = serr.New(serr.ErrInvalid, "delete should have a where clauses")
+var ErrUpdateWithoutWhere error = serr.New(serr.ErrInvalid, "update should have a where clauses")
+var ErrVersionConflict error = serr.New(serr.ErrConflict, "update rejected by version conflict")
+var ErrVersionNil error = serr.New(serr.ErrInvalid, "version column is missing in update data")
+var ErrJoinUnsupported error = serr.New(serr.ErrInvalid, "join is only supported by readers")
 
 // 选项
 type RepoSealOptions struct {
 	TX      interface{}
 	DB      interface{}
+	Router  *Router // 读写分离路由，优先级低于TX，高于DB
 	Name    string
 	Columns []string
+	Joins   []string // 关联表，读取时拼接在表名之后
+
+	AllowFullTable bool // 允许无条件更新及删除全表
+
+	FenceColumn string // fencing token字段
+	FenceToken  int64  // 当前持有的fencing token
+
+	VersionColumn string // 乐观锁版本号字段
+
+	SoftDeleteColumn string // 软删除字段，删除时写入删除时间
+	IncludeDeleted   bool   // 读取时包含已软删除的数据
+
+	UpsertColumns    []string // 主键冲突时更新的字段
+	UpsertAllColumns bool     // 主键冲突时更新除UpsertKeyColumns外的全部字段
+	UpsertKeyColumns []string // 主键及唯一键字段，PostgreSQL及SQLite作为冲突字段
+
+	ReturningColumn string // PostgreSQL写入时通过RETURNING返回的自增字段，默认为id
+	ReturningSet    bool   // 是否通过WithReturning显式配置，显式配置为空时不使用RETURNING
+
+	Sharding      *Sharding // 分片配置，表名及数据库根据context中的分片键确定
+	ScatterOrder  []string  // 跨分片读取的排序字段
+	ScatterLimit  int64     // 跨分片读取的条数
+	ScatterOffset int64     // 跨分片读取的偏移量
+
+	PageOrder []string // 游标分页的排序字段
+	PageTotal bool     // 游标分页时返回总条数
+
+	BeforeHooks map[HookOp][]RepoHook // 操作执行前的钩子
+	AfterHooks  map[HookOp][]RepoHook // 操作执行后的钩子
+	HookTx      bool                  // 钩子需要与操作在同一事务中执行
+
+	BulkChunkSize int              // 批量写入每条语句的行数
+	BulkParallel  int              // 批量写入的并发数
+	BulkTx        BulkTxMode       // 批量写入的事务模式
+	BulkProgress  func(rows int64) // 批量写入进度回调，参数为已写入行数
+
+	BatchKey string // 批量更新的主键字段
+
+	ScanKey   string // 全表扫描的主键字段
+	ScanBatch int64  // 全表扫描每批读取的条数
+
+	Timeout    time.Duration        // 单次操作的超时时间
+	RetryTimes int                  // 可重试错误的最大重试次数
+	RetrySpan  time.Duration        // 首次重试间隔，之后每次翻倍
+	RetryFn    func(err error) bool // 判断错误是否可重试，默认IsRetryable
+	Idempotent bool                 // 写入是否幂等，幂等时写入同样重试
+	Breaker    *Breaker             // 熔断器
+
+	Err error // 配置过程中的错误，如WithModel的模型不是结构体，调用读写对象时返回
 }
 
 // RepoSealOptionHandler Seal数据库配置选项
@@ -48,6 +104,13 @@ func WithTX(tx interface{}) RepoSealOptionHandler {
 	}
 }
 
+// WithRouter 读写分离路由，读操作使用从库，写操作使用主库
+func WithRouter(r *Router) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Router = r
+	}
+}
+
 // WithColumns 配置表字段
 func WithColumns(columns []string) RepoSealOptionHandler {
 	return func(opts *RepoSealOptions) {
@@ -55,9 +118,218 @@ func WithColumns(columns []string) RepoSealOptionHandler {
 	}
 }
 
+// WithAllowFullTable 允许无条件更新及删除全表，默认拒绝执行
+func WithAllowFullTable() RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.AllowFullTable = true
+	}
+}
+
+// WithJoin 关联表，typ为INNER JOIN、LEFT JOIN等，on为关联条件，如【t1.id=t2.tid】
+// 关联读取时Columns需带表名前缀，软删除字段自动带主表名前缀；仅用于读取，更新及删除时返回ErrJoinUnsupported
+func WithJoin(typ string, table string, on string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Joins = append(opts.Joins, typ+" "+table+" ON "+on)
+	}
+}
+
+// WithInnerJoin 内关联
+func WithInnerJoin(table string, on string) RepoSealOptionHandler {
+	return WithJoin("INNER JOIN", table, on)
+}
+
+// WithLeftJoin 左关联
+func WithLeftJoin(table string, on string) RepoSealOptionHandler {
+	return WithJoin("LEFT JOIN", table, on)
+}
+
+// WithFencing 使用fencing token保护更新
+// 更新时附加条件【column <= token】并将column设置为token，持有过期token的写入将被数据库拒绝
+// 影响行数为0时重新读取token，大于当前token时返回ErrFenceTokenRejected，数据不存在或未变化时返回0
+func WithFencing(column string, token int64) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.FenceColumn = column
+		opts.FenceToken = token
+	}
+}
+
+// WithVersion 使用版本号乐观锁保护更新
+// 更新数据需包含读取时的版本号，更新时附加条件【column = 版本号】并设置【column = column + 1】，
+// 版本号不匹配时返回ErrVersionConflict。结构体字段带omitempty时版本号不能为0
+func WithVersion(column string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.VersionColumn = column
+	}
+}
+
+// WithSoftDelete 软删除
+// 删除变为【UPDATE ... SET column=?】，读取时自动附加【column IS NULL】
+func WithSoftDelete(column string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.SoftDeleteColumn = column
+	}
+}
+
+// WithIncludeDeleted 软删除模式下读取包含已删除的数据
+func WithIncludeDeleted() RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.IncludeDeleted = true
+	}
+}
+
+// WithUpsertColumns 主键冲突时更新的字段
+func WithUpsertColumns(columns ...string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.UpsertColumns = columns
+		opts.UpsertAllColumns = false
+	}
+}
+
+// WithUpsertAllColumns 主键冲突时更新除keyColumns外的全部写入字段
+func WithUpsertAllColumns(keyColumns ...string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.UpsertColumns = nil
+		opts.UpsertAllColumns = true
+		opts.UpsertKeyColumns = keyColumns
+	}
+}
+
+// WithUpsertKeyColumns 主键及唯一键字段，PostgreSQL及SQLite插入或更新时作为ON CONFLICT的冲突字段
+func WithUpsertKeyColumns(keyColumns ...string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.UpsertKeyColumns = keyColumns
+	}
+}
+
+// WithReturning PostgreSQL写入时通过RETURNING返回的自增字段，为空时不使用RETURNING，用于没有自增字段的表，写入返回的ID为0
+func WithReturning(column string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.ReturningColumn = column
+		opts.ReturningSet = true
+	}
+}
+
+// WithSharding 分片配置，需通过WithShardKey在context中设置分片键
+func WithSharding(s Sharding) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Sharding = &s
+	}
+}
+
+// WithScatterOrder 跨分片读取的排序字段，格式同OrderBy，如【c1 DESC】
+func WithScatterOrder(cols ...string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.ScatterOrder = cols
+	}
+}
+
+// WithScatterLimit 跨分片读取合并排序后的条数及偏移量
+func WithScatterLimit(limit int64, offset int64) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.ScatterLimit = limit
+		opts.ScatterOffset = offset
+	}
+}
+
+// WithPageOrder 游标分页的排序字段，格式同OrderBy，组合需唯一，如【created_at DESC, id DESC】
+func WithPageOrder(cols ...string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.PageOrder = cols
+	}
+}
+
+// WithPageTotal 游标分页时额外查询总条数
+func WithPageTotal() RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.PageTotal = true
+	}
+}
+
+// WithBulkChunkSize 批量写入每条语句的行数，避免超出max_allowed_packet及占位符数量限制
+// NewSealMysqlMultiInserter配置后按该行数分批写入，同时适用于NewSealMysqlBatchUpdater
+func WithBulkChunkSize(size int) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.BulkChunkSize = size
+	}
+}
+
+// WithBulkParallel 批量写入的并发数，在事务中执行时固定为1
+func WithBulkParallel(n int) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.BulkParallel = n
+	}
+}
+
+// WithBulkTx 批量写入的事务模式
+func WithBulkTx(mode BulkTxMode) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.BulkTx = mode
+	}
+}
+
+// WithBulkProgress 批量写入进度回调，每批写入成功后调用
+func WithBulkProgress(fn func(rows int64)) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.BulkProgress = fn
+	}
+}
+
+// WithBatchKey 批量更新的主键字段，每行数据需包含该字段
+func WithBatchKey(key string) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.BatchKey = key
+	}
+}
+
+// WithScanKey 全表扫描按key字段升序分批读取，key需唯一且包含在Columns中，通常为自增主键
+func WithScanKey(key string, batch int64) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.ScanKey = key
+		opts.ScanBatch = batch
+	}
+}
+
+// WithTimeout 单次操作的超时时间，包括重试；全表扫描每批单独计算，逐行读取时不设置
+func WithTimeout(d time.Duration) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Timeout = d
+	}
+}
+
+// WithRetry 可重试错误(死锁、锁等待超时、连接失效等)的重试，间隔从span开始指数增长
+// 读取总是重试，写入仅在WithIdempotent时重试，事务中不重试，需通过WithTxRetry重试整个事务
+func WithRetry(times int, span time.Duration) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.RetryTimes = times
+		opts.RetrySpan = span
+	}
+}
+
+// WithRetryFn 判断错误是否可重试
+func WithRetryFn(fn func(err error) bool) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.RetryFn = fn
+	}
+}
+
+// WithIdempotent 写入是幂等的，重复执行不影响结果，如按主键更新为固定值
+func WithIdempotent() RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Idempotent = true
+	}
+}
+
+// WithBreaker 熔断器，同一数据库实例的Repo使用同一个熔断器，熔断时返回ErrCircuitOpen
+func WithBreaker(b *Breaker) RepoSealOptionHandler {
+	return func(opts *RepoSealOptions) {
+		opts.Breaker = b
+	}
+}
+
 // ClauseHandler SQL子句处理方法
-// @params query 查询器对象或者TX、DB等
-type ClauseHandler func(query interface{})
+// @params query 查询器对象，*query.SelectQuery、*query.UpdateQuery或*query.DeleteQuery
+// @return 子句不适用于该查询器时返回错误，读写对象将终止执行
+type ClauseHandler func(query interface{}) error
 
 // RepoInserter 数据插入
 // @params data 需要插入的数据，支持单个数据或者数组
@@ -69,6 +341,11 @@ type RepoInserter func(ctx context.Context, data interface{}) (int64, error)
 // @return 最后一个自增ID的值
 type RepoUpdater func(ctx context.Context, data interface{}, where ...ClauseHandler) (int64, error)
 
+// RepoDeleter 数据删除
+// @parama where 删除数据的条件，不能为空
+// @return 影响的行数
+type RepoDeleter func(ctx context.Context, where ...ClauseHandler) (int64, error)
+
 // RepoReader 数据读取
 // @params data 承载数据的指针
 // @params where 查询字句
@@ -78,31 +355,3 @@ type RepoReader func(ctx context.Context, data interface{}, where ...ClauseHandl
 // @params data 承载数据的指针
 // @params params 查询条件字段
 type RepoGroupReader func(context.Context, interface{}, interface{}) error
-
-// RepoGroupOptions 自定义复杂操作集合
-type RepoGroupOptions struct {
-	Handler     interface{}
-	ExecLogFunc meta.RepoExecLogFunc
-}
-
-// RepoGroupOptionHandler 数据库配置选项
-type RepoGroupOptionHandler func(*RepoGroupOptions)
-
-// DefaultRepoGroupOptions 创建默认的Repo配置
-func DefaultRepoGroupOptions() RepoGroupOptions {
-	return RepoGroupOptions{}
-}
-
-// WithHandler 处理函数
-func WithHandler(h interface{}) RepoGroupOptionHandler {
-	return func(opts *RepoGroupOptions) {
-		opts.Handler = h
-	}
-}
-
-// WithExecLogger 日志函数
-func WithExecLogger(fn meta.RepoExecLogFunc) RepoGroupOptionHandler {
-	return func(opts *RepoGroupOptions) {
-		opts.ExecLogFunc = fn
-	}
-}
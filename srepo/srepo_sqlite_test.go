@@ -0,0 +1,446 @@
+package srepo
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/locker"
+	"github.com/rumis/storage/serr"
+)
+
+//go:embed testdata/migrations
+var testMigrations embed.FS
+
+func TestRepoSqlite(t *testing.T) {
+	sealDb, err := seal.Open("sqlite3", filepath.Join(t.TempDir(), "srepo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sealDb.Close()
+	if DialectOf(sealDb.Builder()) != DialectSqlite {
+		t.Fatal("dialect should be sqlite")
+	}
+
+	ctx := context.Background()
+	_, err = sealDb.ExecContext(ctx, `CREATE TABLE test_t1 (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		c1 INTEGER NOT NULL UNIQUE,
+		c2 INTEGER NOT NULL DEFAULT 0,
+		version INTEGER NOT NULL DEFAULT 0,
+		deleted_at DATETIME NULL
+	)`).RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := WithColumns([]string{"c1", "c2"})
+
+	// 写入
+	lastId, err := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))(ctx, T1{C1: 1, C2: 10})
+	if err != nil || lastId != 1 {
+		t.Fatal(lastId, err)
+	}
+	lastId, err = NewSealMysqlMultiInserter(WithDB(sealDb), WithName("test_t1"), WithBulkChunkSize(2))(ctx, []T1{{C1: 2, C2: 20}, {C1: 3, C2: 30}, {C1: 4, C2: 40}})
+	if err != nil || lastId != 4 {
+		t.Fatal(lastId, err)
+	}
+	_, err = NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))(ctx, T1{C1: 1, C2: 10})
+	if !errors.Is(err, serr.ErrDuplicate) {
+		t.Fatal(err)
+	}
+
+	// 读取
+	var one T1
+	err = NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), cols)(ctx, &one, SealEq("c1", 2))
+	if err != nil || one.C2 != 20 {
+		t.Fatal(one, err)
+	}
+	var all []T1
+	err = NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), cols)(ctx, &all, SealQOrderBy("c1 DESC"))
+	if err != nil || len(all) != 4 || all[0].C1 != 4 {
+		t.Fatal(all, err)
+	}
+
+	// 更新
+	cnt, err := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"))(ctx, map[string]interface{}{"c2": 21}, SealEq("c1", 2))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	cnt, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("c1"))(ctx, []T1{{C1: 3, C2: 31}, {C1: 4, C2: 41}})
+	if err != nil || cnt != 2 {
+		t.Fatal(cnt, err)
+	}
+	cnt, err = NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithVersion("version"))(ctx, map[string]interface{}{"c2": 42, "version": 1}, SealEq("c1", 4))
+	if err != ErrVersionConflict {
+		t.Fatal(cnt, err)
+	}
+
+	// 插入或更新、忽略写入
+	ur, err := NewSealMysqlUpserter(WithDB(sealDb), WithName("test_t1"), WithUpsertAllColumns("c1"))(ctx, T1{C1: 1, C2: 11})
+	if err != nil || ur.Affected != 1 {
+		t.Fatal(ur, err)
+	}
+	_, err = NewSealMysqlUpserter(WithDB(sealDb), WithName("test_t1"), WithUpsertColumns("c2"))(ctx, T1{C1: 1, C2: 12})
+	if err != ErrUpsertKeyColumnsNil {
+		t.Fatal(err)
+	}
+	ur, err = NewSealMysqlMultiInsertIgnorer(WithDB(sealDb), WithName("test_t1"))(ctx, []T1{{C1: 1, C2: 99}, {C1: 5, C2: 50}})
+	if err != nil || ur.Inserted != 1 || ur.Ignored != 1 {
+		t.Fatal(ur, err)
+	}
+
+	// 聚合
+	total, err := NewSealMysqlCounter(WithDB(sealDb), WithName("test_t1"))(ctx)
+	if err != nil || total != 5 {
+		t.Fatal(total, err)
+	}
+	sum, err := NewSealMysqlSummer("c2", WithDB(sealDb), WithName("test_t1"))(ctx, SealOp("c1", "<=", 4))
+	if err != nil || sum != 11+21+31+41 {
+		t.Fatal(sum, err)
+	}
+
+	// 游标分页
+	pager := NewSealMysqlPager(WithDB(sealDb), WithName("test_t1"), cols, WithPageOrder("c1"))
+	var page1, page2 []T1
+	page, err := pager(ctx, &page1, "", 3)
+	if err != nil || !page.HasMore || len(page1) != 3 {
+		t.Fatal(page, page1, err)
+	}
+	page, err = pager(ctx, &page2, page.NextCursor, 3)
+	if err != nil || page.HasMore || len(page2) != 2 || page2[0].C1 != 4 {
+		t.Fatal(page, page2, err)
+	}
+
+	// 事务回滚，内层savepoint回滚不影响外层
+	inserter := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))
+	errBiz := errors.New("biz error")
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		_, err := inserter(ctx, T1{C1: 6})
+		if err != nil {
+			return err
+		}
+		err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+			_, err := inserter(ctx, T1{C1: 7})
+			if err != nil {
+				return err
+			}
+			return errBiz
+		})
+		if err != errBiz {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		_, err := inserter(ctx, T1{C1: 8})
+		if err != nil {
+			return err
+		}
+		return errBiz
+	})
+	if err != errBiz {
+		t.Fatal(err)
+	}
+	var c1s []int64
+	err = NewSealMysqlPlucker("c1", WithDB(sealDb), WithName("test_t1"))(ctx, &c1s, SealQOrderBy("c1"))
+	if err != nil || !reflect.DeepEqual(c1s, []int64{1, 2, 3, 4, 5, 6}) {
+		t.Fatal(c1s, err)
+	}
+
+	// 软删除及删除
+	cnt, err = NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"), WithSoftDelete("deleted_at"))(ctx, SealIn("c1", 5, 6))
+	if err != nil || cnt != 2 {
+		t.Fatal(cnt, err)
+	}
+	cnt, err = NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"))(ctx, SealEq("c1", 4))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	exist, err := NewSealMysqlExister(WithDB(sealDb), WithName("test_t1"), WithSoftDelete("deleted_at"))(ctx, SealEq("c1", 5))
+	if err != nil || exist {
+		t.Fatal(exist, err)
+	}
+
+	// 逐行读取
+	cur, err := NewSealMysqlCursorReader(WithDB(sealDb), WithName("test_t1"), cols, WithSoftDelete("deleted_at"))(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	var n int
+	for cur.Next() {
+		var row T1
+		err = cur.Scan(&row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if cur.Err() != nil || n != 3 {
+		t.Fatal(n, cur.Err())
+	}
+
+	// 审计读取更新前数据时SQLite不加FOR UPDATE
+	_, err = sealDb.ExecContext(ctx, `CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		table_name TEXT, row_id TEXT, op TEXT, old_value TEXT, new_value TEXT, operator TEXT, created_at DATETIME
+	)`).RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnt, err = NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithAudit("audit_log", "c1"))(ctx, map[string]interface{}{"c2": 99}, SealEq("c1", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	var ops []string
+	err = NewSealMysqlPlucker("op", WithDB(sealDb), WithName("audit_log"))(ctx, &ops)
+	if err != nil || !reflect.DeepEqual(ops, []string{AuditOpUpdate}) {
+		t.Fatal(ops, err)
+	}
+}
+
+func TestRepoMigrate(t *testing.T) {
+	sealDb, err := seal.Open("sqlite3", filepath.Join(t.TempDir(), "srepo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sealDb.Close()
+
+	ctx := context.Background()
+	seedUp := func(ctx context.Context, q query.Query) error {
+		_, err := q.ExecContext(ctx, "INSERT INTO test_user (name, age) VALUES (?, ?)", "a", 1).RowsAffected()
+		return err
+	}
+	seed := WithMigrateFunc(3, "seed_user", seedUp, func(ctx context.Context, q query.Query) error {
+		_, err := q.ExecContext(ctx, "DELETE FROM test_user").RowsAffected()
+		return err
+	})
+	migrator, err := NewMigrator(WithMigrateDB(sealDb), WithMigrateFS(testMigrations, "testdata/migrations"), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := func(ms []Migration) []int64 {
+		res := make([]int64, 0, len(ms))
+		for _, m := range ms {
+			res = append(res, m.Version)
+		}
+		return res
+	}
+
+	// DryRun不执行
+	dry, err := NewMigrator(WithMigrateDB(sealDb), WithMigrateFS(testMigrations, "testdata/migrations"), seed, WithMigrateDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := dry.Up(ctx)
+	if err != nil || !reflect.DeepEqual(versions(ms), []int64{1, 2, 3}) {
+		t.Fatal(ms, err)
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil || len(status) != 3 || status[0].Applied {
+		t.Fatal(status, err)
+	}
+
+	// 按版本执行
+	ms, err = migrator.UpTo(ctx, 2)
+	if err != nil || !reflect.DeepEqual(versions(ms), []int64{1, 2}) {
+		t.Fatal(ms, err)
+	}
+	ms, err = migrator.Up(ctx)
+	if err != nil || !reflect.DeepEqual(versions(ms), []int64{3}) {
+		t.Fatal(ms, err)
+	}
+	cnt, err := NewSealMysqlCounter(WithDB(sealDb), WithName("test_user"))(ctx, SealEq("age", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	status, err = migrator.Status(ctx)
+	if err != nil || !status[2].Applied || status[2].AppliedAt.IsZero() {
+		t.Fatal(status, err)
+	}
+
+	// 版本重复
+	_, err = NewMigrator(WithMigrateFS(testMigrations, "testdata/migrations"), WithMigrateFunc(2, "add_user_age", seedUp, nil))
+	if !errors.Is(err, ErrMigrationDuplicate) {
+		t.Fatal(err)
+	}
+
+	// 已执行的迁移被修改
+	_, err = sealDb.ExecContext(ctx, "UPDATE schema_migrations SET checksum='x' WHERE version=1").RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migrator.Up(ctx)
+	if !errors.Is(err, ErrMigrationChecksum) {
+		t.Fatal(err)
+	}
+	status, _ = migrator.Status(ctx)
+	if !status[0].Modified {
+		t.Fatal(status)
+	}
+
+	// 回滚
+	ms, err = migrator.Down(ctx, 2)
+	if err != nil || !reflect.DeepEqual(versions(ms), []int64{3, 2}) {
+		t.Fatal(ms, err)
+	}
+	cnt, err = NewSealMysqlCounter(WithDB(sealDb), WithName("test_user"))(ctx)
+	if err != nil || cnt != 0 {
+		t.Fatal(cnt, err)
+	}
+
+	// 加锁失败
+	held := locker.NewLocker(locker.WithLockerAdder(func(ctx context.Context, key string) bool {
+		return false
+	}), locker.WithLockerRetryTimes(1), locker.WithLockerRetrySpan(time.Millisecond))
+	locked, err := NewMigrator(WithMigrateDB(sealDb), WithMigrateFS(testMigrations, "testdata/migrations"), WithMigrateLocker(held, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = locked.Up(ctx)
+	if err != ErrMigrationLocked {
+		t.Fatal(err)
+	}
+}
+
+type testModel struct {
+	ID        int64      `seal:"id,pk,autoincr,omitempty"`
+	C1        int        `seal:"c1"`
+	C2        int        `seal:"c2"`
+	Version   int        `seal:"version,version"`
+	DeletedAt *time.Time `seal:"deleted_at,softdelete"`
+	Ignored   string     `seal:"-"`
+}
+
+func (testModel) TableName() string {
+	return "test_t1"
+}
+
+type testModelBase struct {
+	ID int64 `seal:"id,pk,autoincr"`
+}
+
+type HTTPLogItem struct {
+	testModelBase `seal:",squash"`
+	Path          string
+}
+
+func TestRepoModel(t *testing.T) {
+	m, err := ParseModel(&testModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &Model{
+		Table:      "test_t1",
+		Columns:    []string{"id", "c1", "c2", "version", "deleted_at"},
+		PrimaryKey: []string{"id"},
+		AutoIncr:   "id",
+		Version:    "version",
+		SoftDelete: "deleted_at",
+	}
+	if !reflect.DeepEqual(m, expect) {
+		t.Fatal(m)
+	}
+	m2, _ := ParseModel(testModel{})
+	if m2 != m {
+		t.Fatal("model should be cached")
+	}
+	m, err = ParseModel(HTTPLogItem{})
+	if err != nil || m.Table != "http_log_item" || !reflect.DeepEqual(m.Columns, []string{"id", "Path"}) {
+		t.Fatal(m, err)
+	}
+	_, err = ParseModel(1)
+	if err != ErrModelType {
+		t.Fatal(err)
+	}
+
+	sealDb, err := seal.Open("sqlite3", filepath.Join(t.TempDir(), "srepo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sealDb.Close()
+	ctx := context.Background()
+	_, err = sealDb.ExecContext(ctx, `CREATE TABLE test_t1 (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		c1 INTEGER NOT NULL UNIQUE,
+		c2 INTEGER NOT NULL DEFAULT 0,
+		version INTEGER NOT NULL DEFAULT 0,
+		deleted_at DATETIME NULL
+	)`).RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := WithModel(testModel{})
+
+	// 模型不是结构体时读写返回错误
+	var x int
+	err = NewSealMysqlOneReader(WithDB(sealDb), WithModel(1))(ctx, &x)
+	if err != ErrModelType {
+		t.Fatal(err)
+	}
+	_, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithModel(1))(ctx, []testModel{{ID: 1}})
+	if err != ErrModelType {
+		t.Fatal(err)
+	}
+
+	// 自增字段为零值时由数据库生成
+	lastId, err := NewSealMysqlInserter(WithDB(sealDb), model)(ctx, testModel{C1: 1, C2: 10})
+	if err != nil || lastId != 1 {
+		t.Fatal(lastId, err)
+	}
+	lastId, err = NewSealMysqlMultiInserter(WithDB(sealDb), model)(ctx, []testModel{{C1: 2, C2: 20}, {C1: 3, C2: 30}})
+	if err != nil || lastId != 3 {
+		t.Fatal(lastId, err)
+	}
+
+	// 读取字段及软删除
+	cnt, err := NewSealMysqlDeleter(WithDB(sealDb), model)(ctx, SealEq("c1", 3))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	var all []testModel
+	err = NewSealMysqlMultiReader(WithDB(sealDb), model)(ctx, &all, SealQOrderBy("id"))
+	if err != nil || len(all) != 2 || all[1].ID != 2 || all[1].C2 != 20 {
+		t.Fatal(all, err)
+	}
+
+	// 乐观锁
+	_, err = NewSealMysqlUpdater(WithDB(sealDb), model)(ctx, map[string]interface{}{"c2": 11, "version": 1}, SealEq("id", 1))
+	if err != ErrVersionConflict {
+		t.Fatal(err)
+	}
+
+	// 批量更新不支持乐观锁，使用主键并仅更新WithColumns指定的字段，已软删除的数据不更新
+	_, err = NewSealMysqlBatchUpdater(WithDB(sealDb), model)(ctx, []testModel{{ID: 1, C2: 12}})
+	if err != ErrBatchVersionUnsupported {
+		t.Fatal(err)
+	}
+	cnt, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("id"), WithColumns([]string{"c2"}), WithSoftDelete("deleted_at"))(ctx,
+		[]testModel{{ID: 1, C2: 12}, {ID: 2, C2: 22}, {ID: 3, C2: 32}})
+	if err != nil || cnt != 2 {
+		t.Fatal(cnt, err)
+	}
+	var one testModel
+	err = NewSealMysqlOneReader(WithDB(sealDb), model)(ctx, &one, SealEq("id", 1))
+	if err != nil || one.C1 != 1 || one.C2 != 12 {
+		t.Fatal(one, err)
+	}
+	_, err = NewSealMysqlUpserter(WithDB(sealDb), model, WithUpsertColumns("c2"))(ctx, testModel{ID: 2, C1: 2, C2: 23})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewSealMysqlOneReader(WithDB(sealDb), model)(ctx, &one, SealEq("id", 2))
+	if err != nil || one.C2 != 23 {
+		t.Fatal(one, err)
+	}
+}
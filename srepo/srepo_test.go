@@ -2,14 +2,19 @@ package srepo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/rumis/seal"
 	"github.com/rumis/seal/builder"
 	"github.com/rumis/seal/query"
-	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/serr"
 )
 
 func TestRepo(t *testing.T) {
@@ -51,13 +56,14 @@ func TestRepo(t *testing.T) {
 	var t2 T1
 	// 读取数据
 	reader := NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
-	err = reader(ctx, &t2, func(q interface{}) {
+	err = reader(ctx, &t2, func(q interface{}) error {
 		sq, ok := q.(*query.SelectQuery)
 		if !ok {
 			t.Fatal("select query not match")
-			return
+			return nil
 		}
 		sq.Where(seal.Eq("c1", 1))
+		return nil
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -76,13 +82,14 @@ func TestRepo(t *testing.T) {
 		C2: 5,
 	}
 	updater := NewSealMysqlUpdater(WithTX(sealTx), WithName("test_t1"))
-	_, err = updater(ctx, t3, func(q interface{}) {
+	_, err = updater(ctx, t3, func(q interface{}) error {
 		sq, ok := q.(*query.UpdateQuery)
 		if !ok {
 			t.Fatal("select query not match")
-			return
+			return nil
 		}
 		sq.Where(seal.Eq("c1", 1))
+		return nil
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -90,13 +97,14 @@ func TestRepo(t *testing.T) {
 
 	// 校验更新
 	reader2 := NewSealMysqlOneReader(WithTX(sealTx), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
-	err = reader2(ctx, &t2, func(q interface{}) {
+	err = reader2(ctx, &t2, func(q interface{}) error {
 		sq, ok := q.(*query.SelectQuery)
 		if !ok {
 			t.Fatal("select query not match")
-			return
+			return nil
 		}
 		sq.Where(seal.Eq("c1", 1))
+		return nil
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -118,8 +126,7 @@ func TestRepo(t *testing.T) {
 	}
 }
 
-func TestRepoGroup(t *testing.T) {
-
+func TestRepoUnit(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
 		t.Fatal(err)
@@ -131,119 +138,1433 @@ func TestRepoGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// mock.ExpectBegin()
-	mock.ExpectExec("INSERT INTO test_t1 (c1, c2) VALUES (?,?)").WithArgs(1, 3).WillReturnResult(sqlmock.NewResult(1, 1))
-	rows := sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 3)
-	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=? LIMIT 1").WithArgs(1).WillReturnRows(rows)
+	ctx := context.Background()
+	var logs []string
+	logFn := func(ctx context.Context, ts time.Duration, args interface{}, err error) {
+		logs = append(logs, fmt.Sprintf("%v %v", args, err != nil))
+	}
+	parent := WithStep("parent", InsertStep(NewSealMysqlInserter(WithDB(sealDb), WithName("test_parent")), func(res UnitResults) interface{} {
+		return map[string]interface{}{"name": "p1"}
+	}))
+	children := WithStep("children", InsertStep(NewSealMysqlMultiInserter(WithDB(sealDb), WithName("test_child")), func(res UnitResults) interface{} {
+		return []map[string]interface{}{{"pid": res["parent"]}, {"pid": res["parent"]}}
+	}))
+	counter := WithStep("counter", UpdateStep(NewSealMysqlUpdater(WithDB(sealDb), WithName("test_parent")), func(res UnitResults) interface{} {
+		return map[string]interface{}{"cnt": 2}
+	}, func(res UnitResults) []ClauseHandler {
+		return []ClauseHandler{SealUEq("id", res["parent"])}
+	}))
 
+	// 全部步骤在一个事务中按顺序执行，后续步骤使用之前步骤的结果
 	mock.ExpectBegin()
-	// update
-	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
-	rows1 := sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 5)
-	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=? LIMIT 1").WithArgs(1).WillReturnRows(rows1)
+	mock.ExpectExec("INSERT INTO test_parent (name) VALUES (?)").WithArgs("p1").WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("INSERT INTO test_child (pid) VALUES (?), (?)").WithArgs(10, 10).WillReturnResult(sqlmock.NewResult(21, 2))
+	mock.ExpectExec("UPDATE test_parent SET cnt=? WHERE id=?").WithArgs(2, 10).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
+	res, err := NewRepoUnit(sealDb, parent, children, counter, WithExecLogger(logFn))(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, UnitResults{"parent": 10, "children": 21, "counter": 1}) {
+		t.Fatal(res)
+	}
+	if !reflect.DeepEqual(logs, []string{"parent false", "children false", "counter false"}) {
+		t.Fatal(logs)
+	}
+
+	// 步骤失败时回滚，后续步骤不再执行
+	logs = nil
+	errInsert := errors.New("insert error")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_parent (name) VALUES (?)").WithArgs("p1").WillReturnResult(sqlmock.NewResult(11, 1))
+	mock.ExpectExec("INSERT INTO test_child (pid) VALUES (?), (?)").WithArgs(11, 11).WillReturnError(errInsert)
+	mock.ExpectRollback()
+	res, err = NewRepoUnit(sealDb, parent, children, counter, WithExecLogger(logFn))(ctx)
+	if !errors.Is(err, errInsert) || res != nil {
+		t.Fatal(res, err)
+	}
+	if !reflect.DeepEqual(logs, []string{"parent false", "children true"}) {
+		t.Fatal(logs)
+	}
+
+	// 更新影响行数为0时回滚
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE test_parent SET cnt=? WHERE id=?").WithArgs(2, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	_, err = NewRepoUnit(sealDb, counter)(ctx)
+	if err != ErrUpdateAffectZeroRows {
+		t.Fatal(err)
+	}
+
+	// 步骤名称重复或为空时不开启事务
+	_, err = NewRepoUnit(sealDb, parent, parent)(ctx)
+	if err != ErrUnitStepDuplicate {
+		t.Fatal(err)
+	}
+	_, err = NewRepoUnit(sealDb, WithStep("nil", nil))(ctx)
+	if err != ErrUnitStepNil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoFencing(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec("UPDATE test_t1 SET fence_token=? WHERE c1=? AND fence_token<=?").WithArgs(int64(7), 1, int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE test_t1 SET fence_token=? WHERE c1=? AND fence_token<=?").WithArgs(int64(6), 1, int64(6)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM test_t1 WHERE c1=? AND fence_token>? LIMIT 1").WithArgs(1, int64(6)).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectExec("UPDATE test_t1 SET fence_token=? WHERE c1=? AND fence_token<=?").WithArgs(int64(7), 2, int64(7)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT 1 FROM test_t1 WHERE c1=? AND fence_token>? LIMIT 1").WithArgs(2, int64(7)).WillReturnRows(sqlmock.NewRows([]string{"1"}))
 
-	// 插入数据
 	ctx := context.Background()
-	inster := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))
-	t1 := T1{
-		C1: 1,
-		C2: 3,
+	updater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithFencing("fence_token", 7))
+	_, err = updater(ctx, map[string]interface{}{}, SealUEq("c1", 1))
+	if err != nil {
+		t.Fatal(err)
 	}
-	groupInster := NewMysqlGroupReader(WithHandler(inster), WithExecLogger(meta.ConsoleMysqlExecLogFunc))
-	var lastId int64
-	err = groupInster(ctx, &lastId, t1)
+
+	// 过期的token写入被拒绝
+	staleUpdater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithFencing("fence_token", 6))
+	_, err = staleUpdater(ctx, map[string]interface{}{}, SealUEq("c1", 1))
+	if err != ErrFenceTokenRejected {
+		t.Fatal(err)
+	}
+
+	// 数据不存在或未变化时不是token被拒绝
+	cnt, err := updater(ctx, map[string]interface{}{}, SealUEq("c1", 2))
+	if err != nil || cnt != 0 {
+		t.Fatal(cnt, err)
+	}
+
+	err = mock.ExpectationsWereMet()
 	if err != nil {
 		t.Fatal(err)
 	}
+}
 
-	var t2 T1
-	// 读取数据
-	reader := NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
+func TestRepoDelete(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
 
-	groupReader := NewMysqlGroupReader(
-		WithExecLogger(meta.ConsoleMysqlExecLogFunc),
-		WithHandler(reader))
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = groupReader(ctx, &t2, []ClauseHandler{func(q interface{}) {
-		sq, ok := q.(*query.SelectQuery)
-		if !ok {
-			t.Fatal("select query not match")
-			return
-		}
-		sq.Where(seal.Eq("c1", 1))
-	}})
+	mock.ExpectExec("DELETE FROM test_t1 WHERE c1=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE test_t1 SET deleted_at=? WHERE c1 IN (?, ?) AND deleted_at IS NULL").WithArgs(sqlmock.AnyArg(), 2, 3).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=? AND deleted_at IS NULL LIMIT 1").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(2, 4))
+
+	ctx := context.Background()
+	// 物理删除
+	deleter := NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"))
+	cnt, err := deleter(ctx, SealDEq("c1", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	// 无条件删除被拒绝
+	_, err = deleter(ctx)
+	if err != ErrDeleteWithoutWhere {
+		t.Fatal(err)
+	}
+
+	// 软删除
+	softDeleter := NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"), WithSoftDelete("deleted_at"))
+	cnt, err = softDeleter(ctx, SealDIn("c1", 2, 3))
+	if err != nil || cnt != 2 {
+		t.Fatal(cnt, err)
+	}
+
+	// 读取自动过滤已删除数据
+	var t2 T1
+	reader := NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}), WithSoftDelete("deleted_at"))
+	err = reader(ctx, &t2, SealQEq("c1", 2))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if t2.C2 != t1.C2 {
+	if t2.C1 != 0 {
 		t.Fatal(t2)
 	}
+	// 读取包含已删除数据
+	var ts []T1
+	allReader := NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}), WithSoftDelete("deleted_at"), WithIncludeDeleted())
+	err = allReader(ctx, &ts, SealQEq("c1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 1 || ts[0].C2 != 4 {
+		t.Fatal(ts)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoUpsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES (?) ON DUPLICATE KEY UPDATE c1=VALUES(c1)")).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec(`INSERT INTO test_t1 \(c\d, c\d\) VALUES \(\?,\?\), \(\?,\?\), \(\?,\?\) ON DUPLICATE KEY UPDATE c2=VALUES\(c2\)$`).WillReturnResult(sqlmock.NewResult(3, 4))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO test_t1 (c1) VALUES (?), (?)")).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	// 单行更新
+	upserter := NewSealMysqlUpserter(WithDB(sealDb), WithName("test_t1"), WithUpsertColumns("c1"))
+	res, err := upserter(ctx, map[string]interface{}{"c1": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated != 1 || res.Inserted != 0 {
+		t.Fatal(res)
+	}
+
+	// 多行写入，更新除主键外的全部字段
+	multiUpserter := NewSealMysqlMultiUpserter(WithDB(sealDb), WithName("test_t1"), WithUpsertAllColumns("c1"))
+	res, err = multiUpserter(ctx, []T1{{C1: 1, C2: 2}, {C1: 2, C2: 3}, {C1: 3, C2: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated != 1 || res.Inserted != 2 {
+		t.Fatal(res)
+	}
+
+	// 未指定更新字段
+	_, err = NewSealMysqlUpserter(WithDB(sealDb), WithName("test_t1"))(ctx, T1{C1: 1})
+	if err != ErrUpsertColumnsNil {
+		t.Fatal(err)
+	}
 
+	// 事务中忽略冲突
 	sealTx, err := sealDb.Begin()
 	if err != nil {
 		t.Fatal(err)
 	}
-	// 更新数据
-	t3 := T1{
-		C2: 5,
+	ignorer := NewSealMysqlMultiInsertIgnorer(WithTX(sealTx), WithName("test_t1"))
+	res, err = ignorer(ctx, []map[string]interface{}{{"c1": 1}, {"c1": 2}})
+	if err != nil {
+		t.Fatal(err)
 	}
-	updater := NewSealMysqlUpdater(WithTX(sealTx), WithName("test_t1"))
+	if res.Inserted != 1 || res.Ignored != 1 {
+		t.Fatal(res)
+	}
+	sealTx.Commit()
 
-	groupUpdater := NewMysqlGroupReader(WithHandler(updater))
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
 
-	err = groupUpdater(ctx, t3, []ClauseHandler{func(q interface{}) {
-		sq, ok := q.(*query.UpdateQuery)
-		if !ok {
-			t.Fatal("select query not match")
-			return
+type T1 struct {
+	C1 int `seal:"c1,omitempty"`
+	C2 int `seal:"c2,omitempty"`
+}
+
+func TestRepoWithinTx(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	inserter := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))
+	deleter := NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"))
+
+	// 提交，事务内的读写对象自动使用context中的事务
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		if _, ok := TxFromContext(ctx); !ok {
+			t.Fatal("tx should be in context")
 		}
-		sq.Where(seal.Eq("c1", 1))
-	}})
+		_, err := inserter(ctx, map[string]interface{}{"c1": 1})
+		if err != nil {
+			return err
+		}
+		_, err = deleter(ctx, SealDEq("c1", 2))
+		return err
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// 校验更新
-	reader2 := NewSealMysqlOneReader(WithTX(sealTx), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
-	err = reader2(ctx, &t2, func(q interface{}) {
-		sq, ok := q.(*query.SelectQuery)
-		if !ok {
-			t.Fatal("select query not match")
-			return
+	// 返回错误时回滚
+	errBiz := errors.New("biz error")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(3).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectRollback()
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		_, err := inserter(ctx, map[string]interface{}{"c1": 3})
+		if err != nil {
+			return err
 		}
-		sq.Where(seal.Eq("c1", 1))
+		return errBiz
+	})
+	if err != errBiz {
+		t.Fatal(err)
+	}
+
+	// 嵌套事务使用savepoint，内层失败只回滚内层
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(4).WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec("SAVEPOINT srepo_sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(5).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT srepo_sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT srepo_sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT srepo_sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		_, err := inserter(ctx, map[string]interface{}{"c1": 4})
+		if err != nil {
+			return err
+		}
+		err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+			_, err := inserter(ctx, map[string]interface{}{"c1": 5})
+			if err != nil {
+				return err
+			}
+			return errBiz
+		})
+		if err != errBiz {
+			t.Fatal(err)
+		}
+		return WithinTx(ctx, sealDb, func(ctx context.Context) error {
+			return nil
+		})
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	sealTx.Commit() // 提交事务
 
-	if t2.C2 != 5 {
-		t.Fatal("update error")
+	// panic时回滚并继续抛出
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal("panic should be rethrown")
+			}
+		}()
+		WithinTx(ctx, sealDb, func(ctx context.Context) error {
+			panic("biz panic")
+		})
+	}()
+
+	// 设置隔离级别需提供*sql.DB
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error { return nil }, WithTxIsolation(sql.LevelSerializable))
+	if err != ErrTxSqlDBNil {
+		t.Fatal(err)
 	}
 
-	// tx, _ := db.Begin()
-	// _, err = tx.Exec("INSERT INTO test_t1(c1,c2) values(?,?)", 1, 3)
-	// tx.Commit()
+	// 嵌套事务不能更换db或设置隔离级别、只读
+	otherDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		err := WithinTx(ctx, otherDb, func(ctx context.Context) error { return nil })
+		if err != ErrTxNestedDB {
+			t.Fatal(err)
+		}
+		err = WithinTx(ctx, sealDb, func(ctx context.Context) error { return nil }, WithTxReadOnly())
+		if err != ErrTxNestedOptions {
+			t.Fatal(err)
+		}
+		return WithinTx(ctx, sealDb, func(ctx context.Context) error { return nil }, WithTxIsolation(sql.LevelSerializable), WithTxSqlDB(db))
+	})
+	if err != ErrTxNestedOptions {
+		t.Fatal(err)
+	}
 
-	// 确保所有期望合格
 	err = mock.ExpectationsWereMet()
 	if err != nil {
 		t.Fatal(err)
 	}
+}
+
+func TestRepoRouter(t *testing.T) {
+	newMock := func() (seal.DB, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealDb, mock
+	}
+	primary, pMock := newMock()
+	r1, r1Mock := newMock()
+	r2, r2Mock := newMock()
+
+	ctx := context.Background()
+	router := NewRouter(primary, WithRouterUnhealthy(2, time.Minute)).AddReplica(r1, 1).AddReplica(r2, 1)
+	inserter := NewSealMysqlInserter(WithRouter(router), WithName("test_t1"))
+	reader := NewSealMysqlMultiReader(WithRouter(router), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
+
+	// 轮询从库
+	r1Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 1))
+	r2Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 2))
+	for _, c2 := range []int{1, 2} {
+		var ts []T1
+		err := reader(ctx, &ts, SealQEq("c1", 1))
+		if err != nil || len(ts) != 1 || ts[0].C2 != c2 {
+			t.Fatal(ts, err)
+		}
+	}
+
+	// 从库连续失败后不再路由
+	errConn := errors.New("connection refused")
+	r1Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnError(errConn)
+	r2Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	r1Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnError(errConn)
+	r2Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	r2Mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	for i, e := range []error{errConn, nil, errConn, nil, nil} {
+		var ts []T1
+		err := reader(ctx, &ts, SealQEq("c1", 2))
+		if err != e {
+			t.Fatal(i, err)
+		}
+	}
+
+	// 写操作使用主库，读己之写
+	pMock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(3).WillReturnResult(sqlmock.NewResult(3, 1))
+	pMock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(3, 3))
+	rctx := WithReadYourWrites(ctx)
+	_, err := inserter(rctx, map[string]interface{}{"c1": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ts []T1
+	err = reader(rctx, &ts, SealQEq("c1", 3))
+	if err != nil || len(ts) != 1 {
+		t.Fatal(ts, err)
+	}
 
+	// 按权重轮询
+	weighted := NewRouter(primary, WithRouterBalance(RouterBalanceWeighted)).AddReplica(r1, 2).AddReplica(r2, 1)
+	var picked []*replica
+	for i := 0; i < 3; i++ {
+		picked = append(picked, weighted.pick())
+	}
+	if picked[0] != weighted.replicas[0] || picked[1] != weighted.replicas[1] || picked[2] != weighted.replicas[0] {
+		t.Fatal("weighted balance error")
+	}
+
+	for _, m := range []sqlmock.Sqlmock{pMock, r1Mock, r2Mock} {
+		err = m.ExpectationsWereMet()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
-func TestHandlerType(t *testing.T) {
-	groupUpdater := NewMysqlGroupReader(WithHandler(RepoGroupReader(func(ctx context.Context, out interface{}, params interface{}) error {
-		fmt.Sprintln("test run")
-		return nil
-	})))
-	groupUpdater(context.Background(), 2, 3)
+func TestRepoShard(t *testing.T) {
+	newMock := func(inst string) sqlmock.Sqlmock {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetSealDB(inst, sealDb)
+		return mock
+	}
+	// test_t1_00、test_t1_01位于shard0，test_t1_02、test_t1_03位于shard1
+	s0Mock := newMock("shard0")
+	s1Mock := newMock("shard1")
+	sharding := NewModSharding(4, "shard0", "shard1")
+
+	ctx := context.Background()
+	inserter := NewSealMysqlInserter(WithSharding(sharding), WithName("test_t1"))
+	reader := NewSealMysqlOneReader(WithSharding(sharding), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))
+
+	// 按分片键读写
+	s1Mock.ExpectExec("INSERT INTO test_t1_02 (c1) VALUES (?)").WithArgs(6).WillReturnResult(sqlmock.NewResult(1, 1))
+	s0Mock.ExpectQuery("SELECT c1,c2 FROM test_t1_01 WHERE c1=? LIMIT 1").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(5, 1))
+	_, err := inserter(WithShardKey(ctx, 6), map[string]interface{}{"c1": 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var t1 T1
+	err = reader(WithShardKey(ctx, 5), &t1, SealQEq("c1", 5))
+	if err != nil || t1.C1 != 5 {
+		t.Fatal(t1, err)
+	}
+	// 缺少分片键
+	err = reader(ctx, &t1, SealQEq("c1", 5))
+	if err != ErrShardKeyNil {
+		t.Fatal(err)
+	}
+
+	// 跨分片读取，合并排序后截取
+	s0Mock.MatchExpectationsInOrder(false)
+	s1Mock.MatchExpectationsInOrder(false)
+	s0Mock.ExpectQuery("SELECT c1,c2 FROM test_t1_00 WHERE c1>? ORDER BY c2 DESC LIMIT 3").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 9).AddRow(2, 3))
+	s0Mock.ExpectQuery("SELECT c1,c2 FROM test_t1_01 WHERE c1>? ORDER BY c2 DESC LIMIT 3").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(3, 7))
+	s1Mock.ExpectQuery("SELECT c1,c2 FROM test_t1_02 WHERE c1>? ORDER BY c2 DESC LIMIT 3").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	s1Mock.ExpectQuery("SELECT c1,c2 FROM test_t1_03 WHERE c1>? ORDER BY c2 DESC LIMIT 3").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(4, 8).AddRow(5, 1))
+	scatter := NewSealMysqlScatterReader(WithSharding(sharding), WithName("test_t1"), WithColumns([]string{"c1", "c2"}),
+		WithScatterOrder("c2 DESC"), WithScatterLimit(2, 1))
+	var ts []T1
+	err = scatter(ctx, &ts, SealQOp("c1", ">", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts[0].C1 != 4 || ts[1].C1 != 3 {
+		t.Fatal(ts)
+	}
+
+	// 分表数不合法
+	bad := NewModSharding(0)
+	err = NewSealMysqlOneReader(WithSharding(bad), WithName("test_t1"))(WithShardKey(ctx, 5), &t1)
+	if err != ErrShardTablesInvalid {
+		t.Fatal(err)
+	}
+	err = NewSealMysqlScatterReader(WithSharding(bad), WithName("test_t1"))(ctx, &ts)
+	if err != ErrShardTablesInvalid {
+		t.Fatal(err)
+	}
+
+	// 数值及数值字符串按数值比较，其他字符串按字节比较
+	if sealCompare(int64(9), int64(10)) >= 0 || sealCompare("9", "10") >= 0 || sealCompare([]byte("9"), []byte("10")) >= 0 ||
+		sealCompare([]byte("9.5"), int64(10)) >= 0 || sealCompare([]byte("b"), []byte("a10")) <= 0 {
+		t.Fatal("compare error")
+	}
+
+	for _, m := range []sqlmock.Sqlmock{s0Mock, s1Mock} {
+		err = m.ExpectationsWereMet()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
-type T1 struct {
-	C1 int `seal:"c1,omitempty"`
-	C2 int `seal:"c2,omitempty"`
+func TestRepoPager(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cols := []string{"c1", "c2"}
+
+	// 偏移分页
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1>? ORDER BY c1 DESC LIMIT 2 OFFSET 4").WithArgs(0).WillReturnRows(sqlmock.NewRows(cols).AddRow(5, 1))
+	var ts []T1
+	reader := NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), WithColumns(cols))
+	err = reader(ctx, &ts, SealQOp("c1", ">", 0), SealQOrderBy("c1 DESC"), SealQLimit(2), SealQOffset(4))
+	if err != nil || len(ts) != 1 {
+		t.Fatal(ts, err)
+	}
+
+	// 游标分页，首页返回总条数
+	mock.ExpectQuery("SELECT COUNT(*) AS agg_count FROM test_t1 WHERE c1>?").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"agg_count"}).AddRow(3))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1>? ORDER BY c2 DESC, c1 LIMIT 3").WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 9).AddRow(2, 8).AddRow(3, 8))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1>? AND (c2<? OR (c2=? AND c1>?)) ORDER BY c2 DESC, c1 LIMIT 3").WithArgs(0, int64(8), int64(8), int64(2)).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(3, 8))
+	pager := NewSealMysqlPager(WithDB(sealDb), WithName("test_t1"), WithColumns(cols), WithPageOrder("c2 DESC", "c1"), WithPageTotal())
+	var p1 []T1
+	page, err := pager(ctx, &p1, "", 2, SealQOp("c1", ">", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1) != 2 || !page.HasMore || page.NextCursor == "" || page.Total != 3 {
+		t.Fatal(p1, page)
+	}
+	var p2 []T1
+	pager = NewSealMysqlPager(WithDB(sealDb), WithName("test_t1"), WithColumns(cols), WithPageOrder("c2 DESC", "c1"))
+	page, err = pager(ctx, &p2, page.NextCursor, 2, SealQOp("c1", ">", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p2) != 1 || p2[0].C1 != 3 || page.HasMore || page.NextCursor != "" {
+		t.Fatal(p2, page)
+	}
+	// 无效游标
+	_, err = pager(ctx, &p2, "invalid", 2)
+	if err != ErrPageCursorInvalid {
+		t.Fatal(err)
+	}
+
+	// 游标中的时间保留时区及纳秒，解码后仍为time.Time
+	at := time.Date(2022, 8, 17, 10, 30, 0, 123456789, time.FixedZone("CST", 8*3600))
+	cursor, err := sealEncodeCursor(map[string]interface{}{"c1": int64(1), "c2": at, "c3": "2022-08-17T10:30:00Z"}, []string{"c2", "c3", "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := sealDecodeCursor(cursor, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vt, ok := vals[0].(time.Time); !ok || !vt.Equal(at) || vals[1] != "2022-08-17T10:30:00Z" || vals[2] != int64(1) {
+		t.Fatal(vals)
+	}
+	// 排序字段为NULL时无法生成游标
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 ORDER BY c2 DESC, c1 LIMIT 2").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, nil).AddRow(2, nil))
+	_, err = pager(ctx, &p2, "", 1)
+	if err != ErrPageNullValue {
+		t.Fatal(err)
+	}
+	// 每页条数不合法时不查询
+	for _, size := range []int64{0, -1} {
+		_, err = pager(ctx, &p2, "", size)
+		if err != ErrPageSizeInvalid {
+			t.Fatal(size, err)
+		}
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoClause(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	// 关联查询、OR组合、分组
+	mock.ExpectQuery("SELECT test_t1.c1,test_t2.c2 FROM test_t1 LEFT JOIN test_t2 ON test_t1.c1=test_t2.c1 WHERE (test_t1.c1=? OR test_t2.c2 IS NULL) AND test_t1.c1 NOT IN (?, ?) AND test_t1.c2 BETWEEN ? AND ? AND test_t1.c2 > ? + test_t1.c1 GROUP BY test_t1.c1 HAVING COUNT(*)>?").
+		WithArgs(1, 2, 3, 0, 10, 4, 1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 5))
+	reader := NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), WithLeftJoin("test_t2", "test_t1.c1=test_t2.c1"), WithColumns([]string{"test_t1.c1", "test_t2.c2"}))
+	var ts []T1
+	err = reader(ctx, &ts,
+		SealWhere(CondOr(CondEq("test_t1.c1", 1), CondIsNull("test_t2.c2")), CondNotIn("test_t1.c1", 2, 3)),
+		SealWhere(CondBetween("test_t1.c2", 0, 10), CondRaw("test_t1.c2 > ? + test_t1.c1", 4)),
+		SealGroupBy("test_t1.c1"), SealHaving(CondRaw("COUNT(*)>?", 1)))
+	if err != nil || len(ts) != 1 || ts[0].C2 != 5 {
+		t.Fatal(ts, err)
+	}
+	// 关联读取时软删除字段带主表名前缀
+	mock.ExpectQuery("SELECT test_t1.c1,test_t2.c2 FROM test_t1 INNER JOIN test_t2 ON test_t1.c1=test_t2.c1 WHERE test_t2.c2=? AND test_t1.deleted_at IS NULL").
+		WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 5))
+	join := []RepoSealOptionHandler{WithDB(sealDb), WithName("test_t1"), WithInnerJoin("test_t2", "test_t1.c1=test_t2.c1"), WithSoftDelete("deleted_at")}
+	reader = NewSealMysqlMultiReader(append(join, WithColumns([]string{"test_t1.c1", "test_t2.c2"}))...)
+	err = reader(ctx, &ts, SealQEq("test_t2.c2", 5))
+	if err != nil || len(ts) != 1 {
+		t.Fatal(ts, err)
+	}
+	// 更新及删除不支持关联，不执行
+	_, err = NewSealMysqlUpdater(join...)(ctx, map[string]interface{}{"c2": 1}, SealQEq("test_t2.c2", 5))
+	if err != ErrJoinUnsupported {
+		t.Fatal(err)
+	}
+	_, err = NewSealMysqlDeleter(join...)(ctx, SealQEq("test_t2.c2", 5))
+	if err != ErrJoinUnsupported {
+		t.Fatal(err)
+	}
+	_, err = NewSealMysqlBatchUpdater(append(join, WithBatchKey("c1"))...)(ctx, []map[string]interface{}{{"c1": 1, "c2": 2}})
+	if err != ErrJoinUnsupported {
+		t.Fatal(err)
+	}
+
+	// 更新及删除使用相同的条件
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c2 IS NOT NULL AND c1<?").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM test_t1 WHERE NOT (c1=?)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	updater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"))
+	cnt, err := updater(ctx, map[string]interface{}{"c2": 1}, SealWhere(CondNotNull("c2"), CondOp("c1", "<", 5)))
+	if err != nil || cnt != 2 {
+		t.Fatal(cnt, err)
+	}
+	deleter := NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"))
+	cnt, err = deleter(ctx, SealWhere(CondNot(CondEq("c1", 1))))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoClauseHandler(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	updater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"))
+	// 同一组条件适用于查询、更新及删除
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	cnt, err := updater(ctx, map[string]interface{}{"c2": 2}, SealQEq("c1", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	// 不适用的子句返回错误
+	_, err = updater(ctx, map[string]interface{}{"c2": 2}, SealEq("c1", 1), SealQOrderBy("c1"))
+	if err != ErrClauseUnsupported {
+		t.Fatal(err)
+	}
+	_, err = updater(ctx, map[string]interface{}{"c2": 2}, SealWhere())
+	if err != ErrClauseEmpty {
+		t.Fatal(err)
+	}
+	// 无条件更新被拒绝
+	_, err = updater(ctx, map[string]interface{}{"c2": 2})
+	if err != ErrUpdateWithoutWhere {
+		t.Fatal(err)
+	}
+	// 子句未生成条件时同样拒绝
+	_, err = updater(ctx, map[string]interface{}{"c2": 2}, SealWhere(CondAnd()))
+	if err != ErrUpdateWithoutWhere {
+		t.Fatal(err)
+	}
+	_, err = NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"))(ctx, SealWhere(CondAnd()), func(q interface{}) error { return nil })
+	if err != ErrDeleteWithoutWhere {
+		t.Fatal(err)
+	}
+	_, err = NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"), WithSoftDelete("deleted_at"))(ctx, SealWhere(CondAnd()))
+	if err != ErrDeleteWithoutWhere {
+		t.Fatal(err)
+	}
+	// 显式允许全表更新及删除
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE 1=1").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM test_t1 ").WillReturnResult(sqlmock.NewResult(0, 3))
+	cnt, err = NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithAllowFullTable())(ctx, map[string]interface{}{"c2": 2})
+	if err != nil || cnt != 3 {
+		t.Fatal(cnt, err)
+	}
+	cnt, err = NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"), WithAllowFullTable())(ctx)
+	if err != nil || cnt != 3 {
+		t.Fatal(cnt, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoAggregate(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	mock.ExpectQuery("SELECT COUNT(*) AS agg_count FROM test_t1 WHERE c1>? AND deleted_at IS NULL").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"agg_count"}).AddRow(3))
+	mock.ExpectQuery("SELECT 1 FROM test_t1 WHERE c1=? LIMIT 1").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectQuery("SELECT 1 FROM test_t1 WHERE c1=? LIMIT 1").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mock.ExpectQuery("SELECT SUM(c2) AS agg_sum FROM test_t1 WHERE c1>?").WithArgs(0).WillReturnRows(sqlmock.NewRows([]string{"agg_sum"}).AddRow("12.5"))
+	mock.ExpectQuery("SELECT SUM(c2) AS agg_sum FROM test_t1 WHERE c1>?").WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"agg_sum"}).AddRow(nil))
+	mock.ExpectQuery("SELECT MAX(c2) AS agg_max FROM test_t1").WillReturnRows(sqlmock.NewRows([]string{"agg_max"}).AddRow(7))
+	mock.ExpectQuery("SELECT c1 FROM test_t1 WHERE c2=?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1 IN (?, ?)").WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 3).AddRow(2, 4))
+
+	// 计数，同样过滤软删除数据
+	cnt, err := NewSealMysqlCounter(WithDB(sealDb), WithName("test_t1"), WithSoftDelete("deleted_at"))(ctx, SealOp("c1", ">", 0))
+	if err != nil || cnt != 3 {
+		t.Fatal(cnt, err)
+	}
+	// 存在判断
+	exister := NewSealMysqlExister(WithDB(sealDb), WithName("test_t1"))
+	for i, expect := range []bool{true, false} {
+		c1 := i + 1
+		ok, err := exister(ctx, SealEq("c1", c1))
+		if err != nil || ok != expect {
+			t.Fatal(c1, ok, err)
+		}
+	}
+	// 求和，无数据时为0
+	summer := NewSealMysqlSummer("c2", WithDB(sealDb), WithName("test_t1"))
+	for _, expect := range []float64{12.5, 0} {
+		c1 := 0
+		if expect == 0 {
+			c1 = 9
+		}
+		sum, err := summer(ctx, SealOp("c1", ">", c1))
+		if err != nil || sum != expect {
+			t.Fatal(sum, err)
+		}
+	}
+	// 最大值
+	var max sql.NullInt64
+	err = NewSealMysqlAggregator("MAX", "c2", WithDB(sealDb), WithName("test_t1"))(ctx, &max)
+	if err != nil || max.Int64 != 7 {
+		t.Fatal(max, err)
+	}
+	// 单列读取
+	var ids []int64
+	err = NewSealMysqlPlucker("c1", WithDB(sealDb), WithName("test_t1"))(ctx, &ids, SealEq("c2", 1))
+	if err != nil || len(ids) != 2 || ids[1] != 2 {
+		t.Fatal(ids, err)
+	}
+	// 按字段组织为map
+	var m map[int64]T1
+	err = NewSealMysqlMapReader("c1", WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))(ctx, &m, SealIn("c1", 1, 2))
+	if err != nil || len(m) != 2 || m[2].C2 != 4 {
+		t.Fatal(m, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type T1V struct {
+	C1      int `seal:"c1,omitempty"`
+	C2      int `seal:"c2,omitempty"`
+	Version int `seal:"version,omitempty"`
+}
+
+func TestRepoVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	updateSql := `^UPDATE test_t1 SET (c2=\?, version=version\+1|version=version\+1, c2=\?) WHERE c1=\? AND version=\?$`
+	mock.ExpectExec(updateSql).WithArgs(5, 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateSql).WithArgs(5, 1, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	updater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithVersion("version"))
+	cnt, err := updater(ctx, map[string]interface{}{"c2": 5, "version": 3}, SealEq("c1", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+	// 版本号不匹配
+	_, err = updater(ctx, map[string]interface{}{"c2": 5, "version": 3}, SealEq("c1", 1))
+	if err != ErrVersionConflict {
+		t.Fatal(err)
+	}
+	// 缺少版本号
+	_, err = updater(ctx, map[string]interface{}{"c2": 5}, SealEq("c1", 1))
+	if err != ErrVersionNil {
+		t.Fatal(err)
+	}
+
+	// 冲突时重新读取并修改
+	selectSql := regexp.QuoteMeta("SELECT c1,c2,version FROM test_t1 WHERE c1=? LIMIT 1")
+	retrySql := `^UPDATE test_t1 SET .+ WHERE c1=\? AND version=\?$`
+	mock.ExpectQuery(selectSql).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2", "version"}).AddRow(1, 2, 3))
+	mock.ExpectExec(retrySql).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectSql).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2", "version"}).AddRow(1, 7, 4))
+	mock.ExpectExec(retrySql).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	reader := NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2", "version"}))
+	var row T1V
+	cnt, err = RetryOnVersionConflict(ctx, 3, reader, updater, &row, func(data interface{}) error {
+		data.(*T1V).C2 += 10
+		return nil
+	}, SealEq("c1", 1))
+	if err != nil || cnt != 1 || row.C2 != 17 {
+		t.Fatal(cnt, row, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoHook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithOperatorID(context.Background(), 9)
+
+	// 自动设置时间及操作人，已有的字段保留原值
+	var inserted map[string]interface{}
+	var lastId int64
+	inserter := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"),
+		WithTimestamps("created_at", "updated_at"), WithOperator("created_by", "updated_by"),
+		WithBeforeHook(HookOpInsert, func(ctx context.Context, hc *HookContext) error {
+			inserted = hc.Data.(map[string]interface{})
+			return nil
+		}),
+		WithAfterHook(HookOpInsert, func(ctx context.Context, hc *HookContext) error {
+			lastId = hc.Result
+			return hc.Err
+		}))
+	mock.ExpectExec(`^INSERT INTO test_t1 \(.+\) VALUES \(\?,\?,\?,\?,\?\)$`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	_, err = inserter(ctx, map[string]interface{}{"c1": 1, "created_by": 7})
+	if err != nil || lastId != 3 {
+		t.Fatal(lastId, err)
+	}
+	if inserted["created_by"] != 7 || inserted["updated_by"] != 9 || inserted["created_at"] == nil || inserted["updated_at"] == nil {
+		t.Fatal(inserted)
+	}
+
+	updater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithTimestamps("created_at", "updated_at"))
+	mock.ExpectExec(`^UPDATE test_t1 SET (c2=\?, updated_at=\?|updated_at=\?, c2=\?) WHERE c1=\?$`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	_, err = updater(ctx, map[string]interface{}{"c2": 2}, SealEq("c1", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// before钩子返回错误时终止执行
+	errHook := errors.New("hook error")
+	deleter := NewSealMysqlDeleter(WithDB(sealDb), WithName("test_t1"), WithBeforeHook(HookOpDelete, func(ctx context.Context, hc *HookContext) error {
+		return errHook
+	}))
+	_, err = deleter(ctx, SealEq("c1", 1))
+	if err != errHook {
+		t.Fatal(err)
+	}
+
+	// 审计日志与更新在同一事务中写入，未变化的行不记录
+	auditUpdater := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), WithAudit("audit_log", "c1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT \* FROM test_t1 WHERE c2=\? FOR UPDATE$`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 2).AddRow(2, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE test_t1 SET c2=? WHERE c2=?")).WithArgs(5, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`^SELECT \* FROM test_t1 WHERE c1 IN \(\?, \?\)$`).WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 5).AddRow(2, 2))
+	mock.ExpectExec(`^INSERT INTO audit_log \(.+\) VALUES \(\?,\?,\?,\?,\?,\?,\?\)$`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	cnt, err := auditUpdater(ctx, map[string]interface{}{"c2": 5}, SealEq("c2", 2))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoBulk(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	rows := []map[string]interface{}{{"c1": 1}, {"c1": 2}, {"c1": 3}, {"c1": 4}, {"c1": 5}}
+
+	// 分批写入并回调进度
+	var progress []int64
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?)").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?)").WithArgs(3, 4).WillReturnResult(sqlmock.NewResult(4, 2))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(5).WillReturnResult(sqlmock.NewResult(5, 1))
+	lastId, err := NewSealMysqlMultiInserter(WithDB(sealDb), WithName("test_t1"), WithBulkChunkSize(2), WithBulkProgress(func(n int64) {
+		progress = append(progress, n)
+	}))(ctx, rows)
+	if err != nil || lastId != 5 || !reflect.DeepEqual(progress, []int64{2, 4, 5}) {
+		t.Fatal(lastId, progress, err)
+	}
+
+	// 通道输入，全部数据一个事务，失败时回滚
+	errInsert := errors.New("insert error")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?), (?)").WithArgs(1, 2, 3).WillReturnResult(sqlmock.NewResult(3, 3))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?)").WithArgs(4, 5).WillReturnError(errInsert)
+	mock.ExpectRollback()
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for _, row := range rows {
+			ch <- row
+		}
+	}()
+	bulk := NewSealMysqlBulkInserter(WithDB(sealDb), WithName("test_t1"), WithBulkChunkSize(3), WithBulkTx(BulkTxAll))
+	res, err := bulk(ctx, ChanRows(ctx, ch))
+	if err != errInsert || res.Rows != 3 {
+		t.Fatal(res, err)
+	}
+
+	// 行类型不一致
+	_, err = NewSealMysqlBulkInserter(WithDB(sealDb), WithName("test_t1"))(ctx, SliceRows([]interface{}{map[string]interface{}{"c1": 1}, T1{C1: 2}}))
+	if err != ErrBulkRowType {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发写入，语句执行顺序不确定
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?)").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?), (?)").WithArgs(3, 4).WillReturnResult(sqlmock.NewResult(4, 2))
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(5).WillReturnResult(sqlmock.NewResult(5, 1))
+	res, err = NewSealMysqlBulkInserter(WithDB(sealDb), WithName("test_t1"), WithBulkChunkSize(2), WithBulkParallel(3))(ctx, SliceRows(rows))
+	if err != nil || res.Rows != 5 || res.Chunks != 3 || res.LastId != 5 {
+		t.Fatal(res, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoCursor(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cols := []string{"c1", "c2"}
+	reader := NewSealMysqlCursorReader(WithDB(sealDb), WithName("test_t1"), WithColumns(cols))
+
+	// 逐行读取
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c2=?").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 1).AddRow(2, 1).AddRow(3, 1))
+	cur, err := reader(ctx, SealEq("c2", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	for cur.Next() {
+		var row T1
+		err = cur.Scan(&row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += row.C1
+	}
+	if cur.Err() != nil || sum != 6 {
+		t.Fatal(sum, cur.Err())
+	}
+	cur.Close()
+
+	// 分批读取
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 1).AddRow(2, 1).AddRow(3, 1))
+	cur, err = reader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch []T1
+	var sizes []int
+	for {
+		n, err := cur.NextBatch(&batch, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		sizes = append(sizes, len(batch))
+	}
+	cur.Close()
+	if !reflect.DeepEqual(sizes, []int{2, 1}) {
+		t.Fatal(sizes)
+	}
+
+	// 读取过程中取消
+	cctx, cancel := context.WithCancel(ctx)
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 1).AddRow(2, 1))
+	cur, err = reader(cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cur.Next() {
+		t.Fatal(cur.Err())
+	}
+	cancel()
+	if cur.Next() || cur.Err() != context.Canceled {
+		t.Fatal(cur.Err())
+	}
+	cur.Close()
+
+	// 按主键分批扫描
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c2>? ORDER BY c1 LIMIT 2").WithArgs(0).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 1).AddRow(2, 1))
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c2>? AND c1>? ORDER BY c1 LIMIT 2").WithArgs(0, 2).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(3, 1))
+	scanner := NewSealMysqlScanner(WithDB(sealDb), WithName("test_t1"), WithColumns(cols), WithScanKey("c1", 2))
+	var scanned []int
+	err = scanner(ctx, &batch, func() error {
+		for _, v := range batch {
+			scanned = append(scanned, v.C1)
+		}
+		return nil
+	}, SealOp("c2", ">", 0))
+	if err != nil || !reflect.DeepEqual(scanned, []int{1, 2, 3}) {
+		t.Fatal(scanned, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoBatchUpdate(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	updater := NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("c1"), WithBulkChunkSize(2))
+
+	// 分批执行，行中没有的字段保持原值
+	mock.ExpectExec("UPDATE test_t1 SET c2=CASE c1 WHEN ? THEN ? WHEN ? THEN ? ELSE c2 END, c3=CASE c1 WHEN ? THEN ? ELSE c3 END WHERE c1 IN (?, ?) AND c2>?").
+		WithArgs(1, 10, 2, 20, 2, "b", 1, 2, 0).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE test_t1 SET c2=CASE c1 WHEN ? THEN ? ELSE c2 END WHERE c1=? AND c2>?").
+		WithArgs(3, 30, 3, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	cnt, err := updater(ctx, []map[string]interface{}{
+		{"c1": 1, "c2": 10},
+		{"c1": 2, "c2": 20, "c3": "b"},
+		{"c1": 3, "c2": 30},
+	}, SealOp("c2", ">", 0))
+	if err != nil || cnt != 3 {
+		t.Fatal(cnt, err)
+	}
+
+	// 结构体切片，全部批次一个事务
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE test_t1 SET c2=CASE c1 WHEN ? THEN ? WHEN ? THEN ? ELSE c2 END WHERE c1 IN (?, ?)").
+		WithArgs(1, 5, 2, 6, 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE test_t1 SET c2=CASE c1 WHEN ? THEN ? ELSE c2 END WHERE c1=?").
+		WithArgs(3, 7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	cnt, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("c1"), WithBulkChunkSize(2), WithBulkTx(BulkTxAll))(ctx,
+		[]T1{{C1: 1, C2: 5}, {C1: 2, C2: 6}, {C1: 3, C2: 7}})
+	if err != nil || cnt != 3 {
+		t.Fatal(cnt, err)
+	}
+
+	// 缺少主键
+	_, err = updater(ctx, []map[string]interface{}{{"c2": 1}})
+	if err != ErrBatchKeyMissing {
+		t.Fatal(err)
+	}
+
+	// 仅更新指定字段，附加fencing token及软删除条件
+	mock.ExpectExec("UPDATE test_t1 SET c2=CASE c1 WHEN ? THEN ? ELSE c2 END, fence_token=? WHERE c1=? AND fence_token<=? AND deleted_at IS NULL").
+		WithArgs(1, 5, int64(7), 1, int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+	cnt, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("c1"), WithColumns([]string{"c1", "c2"}),
+		WithFencing("fence_token", 7), WithSoftDelete("deleted_at"))(ctx, []map[string]interface{}{{"c1": 1, "c2": 5, "c3": "x", "fence_token": 9}})
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+
+	// 乐观锁需逐行更新
+	_, err = NewSealMysqlBatchUpdater(WithDB(sealDb), WithName("test_t1"), WithBatchKey("c1"), WithVersion("version"))(ctx, []T1{{C1: 1, C2: 5}})
+	if err != ErrBatchVersionUnsupported {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoPostgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := OpenPostgres(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DialectOf(sealDb.Builder()) != DialectPostgres {
+		t.Fatal("dialect should be postgres")
+	}
+
+	ctx := context.Background()
+	// INSERT ... RETURNING通过查询执行，写入默认不重试，幂等时重试
+	errDeadlock := &testMysqlError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES ($1) RETURNING id")).WithArgs(1).WillReturnError(errDeadlock)
+	_, err = NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"), WithRetry(2, time.Millisecond))(ctx, map[string]interface{}{"c1": 1})
+	if !errors.Is(err, errDeadlock) {
+		t.Fatal(err)
+	}
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES ($1) RETURNING id")).WithArgs(1).WillReturnError(errDeadlock)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES ($1) RETURNING id")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	lastId, err := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"), WithRetry(2, time.Millisecond), WithIdempotent())(ctx, map[string]interface{}{"c1": 1})
+	if err != nil || lastId != 7 {
+		t.Fatal(lastId, err)
+	}
+
+	// 占位符转换为$n，引号中的?不转换，自增ID通过RETURNING获取
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES ($1) RETURNING id")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT c1,c2 FROM test_t1 WHERE c1=$1 AND c2 <> '?' LIMIT 1")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 2))
+	lastId, err = NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"))(ctx, map[string]interface{}{"c1": 1})
+	if err != nil || lastId != 7 {
+		t.Fatal(lastId, err)
+	}
+	var row T1
+	err = NewSealMysqlOneReader(WithDB(sealDb), WithName("test_t1"), WithColumns([]string{"c1", "c2"}))(ctx, &row, SealEq("c1", 1), SealWhere(CondRaw("c2 <> '?'")))
+	if err != nil || row.C2 != 2 {
+		t.Fatal(row, err)
+	}
+
+	// 事务中同样转换占位符
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE test_t1 SET c2=$1 WHERE c1=$2")).WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		_, err := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"))(ctx, map[string]interface{}{"c2": 3}, SealEq("c1", 1))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ON CONFLICT插入或更新，xmax为0的行为新插入的行
+	mock.ExpectQuery(`^INSERT INTO test_t1 \(c\d, c\d\) VALUES \(\$1,\$2\), \(\$3,\$4\) ON CONFLICT \(c1\) DO UPDATE SET c2=EXCLUDED\.c2 RETURNING id, \(xmax = 0\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "?column?"}).AddRow(1, false).AddRow(8, true))
+	ur, err := NewSealMysqlMultiUpserter(WithDB(sealDb), WithName("test_t1"), WithUpsertAllColumns("c1"))(ctx, []T1{{C1: 1, C2: 4}, {C1: 2, C2: 5}})
+	if err != nil || ur.Inserted != 1 || ur.Updated != 1 || ur.LastId != 8 {
+		t.Fatal(ur, err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_t1 (c1) VALUES ($1), ($2) ON CONFLICT DO NOTHING RETURNING id, (xmax = 0)")).WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "?column?"}).AddRow(9, true))
+	ur, err = NewSealMysqlMultiInsertIgnorer(WithDB(sealDb), WithName("test_t1"))(ctx, []map[string]interface{}{{"c1": 1}, {"c1": 3}})
+	if err != nil || ur.Inserted != 1 || ur.Ignored != 1 || ur.LastId != 9 {
+		t.Fatal(ur, err)
+	}
+
+	// 没有自增字段的表显式关闭RETURNING
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO test_kv (k) VALUES ($1)")).WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+	lastId, err = NewSealMysqlInserter(WithDB(sealDb), WithName("test_kv"), WithReturning(""))(ctx, map[string]interface{}{"k": "a"})
+	if err != nil || lastId != 0 {
+		t.Fatal(lastId, err)
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO test_kv (k) VALUES ($1), ($2)")).WithArgs("b", "c").WillReturnResult(sqlmock.NewResult(0, 2))
+	lastId, err = NewSealMysqlMultiInserter(WithDB(sealDb), WithName("test_kv"), WithReturning(""))(ctx, []map[string]interface{}{{"k": "b"}, {"k": "c"}})
+	if err != nil || lastId != 0 {
+		t.Fatal(lastId, err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO test_kv (k) VALUES ($1) ON CONFLICT DO NOTHING RETURNING (xmax = 0)")).WithArgs("a").
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	ur, err = NewSealMysqlInsertIgnorer(WithDB(sealDb), WithName("test_kv"), WithReturning(""))(ctx, map[string]interface{}{"k": "a"})
+	if err != nil || ur.Inserted != 0 || ur.Ignored != 1 || ur.LastId != 0 {
+		t.Fatal(ur, err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMysqlError 与go-sql-driver/mysql的MySQLError结构一致
+type testMysqlError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *testMysqlError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
+func TestRepoRetry(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sealDb, err := seal.OpenWithDB(db, builder.NewMysqlBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	errDeadlock := &testMysqlError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	errShutdown := &testMysqlError{Number: 1053, Message: "Server shutdown in progress"}
+	if !IsRetryable(fmt.Errorf("wrap: %w", errDeadlock)) || IsRetryable(errShutdown) || !IsUnavailable(errShutdown) || IsUnavailable(errDeadlock) {
+		t.Fatal("mysql error classify failed")
+	}
+	retry := WithRetry(2, time.Millisecond)
+	cols := WithColumns([]string{"c1", "c2"})
+
+	// 读取重试
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(1).WillReturnError(errDeadlock)
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}).AddRow(1, 2))
+	var ts []T1
+	err = NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), cols, retry)(ctx, &ts, SealQEq("c1", 1))
+	if err != nil || len(ts) != 1 || ts[0].C2 != 2 {
+		t.Fatal(ts, err)
+	}
+
+	// 写入默认不重试，幂等时重试
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(5, 1).WillReturnError(errDeadlock)
+	_, err = NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), retry)(ctx, map[string]interface{}{"c2": 5}, SealUEq("c1", 1))
+	if !errors.Is(err, errDeadlock) || !errors.Is(err, serr.ErrConflict) {
+		t.Fatal(err)
+	}
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(5, 1).WillReturnError(errDeadlock)
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(5, 1).WillReturnError(errDeadlock)
+	mock.ExpectExec("UPDATE test_t1 SET c2=? WHERE c1=?").WithArgs(5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	cnt, err := NewSealMysqlUpdater(WithDB(sealDb), WithName("test_t1"), retry, WithIdempotent())(ctx, map[string]interface{}{"c2": 5}, SealUEq("c1", 1))
+	if err != nil || cnt != 1 {
+		t.Fatal(cnt, err)
+	}
+
+	// 重新执行整个事务
+	inserter := NewSealMysqlInserter(WithDB(sealDb), WithName("test_t1"), retry)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(1).WillReturnError(errDeadlock)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO test_t1 (c1) VALUES (?)").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	runs := 0
+	err = WithinTx(ctx, sealDb, func(ctx context.Context) error {
+		runs++
+		_, err := inserter(ctx, map[string]interface{}{"c1": 1})
+		return err
+	}, WithTxRetry(1, time.Millisecond))
+	if err != nil || runs != 2 {
+		t.Fatal(runs, err)
+	}
+
+	// 超时
+	mock.ExpectQuery("SELECT c1,c2 FROM test_t1 WHERE c1=?").WithArgs(2).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{"c1", "c2"}))
+	err = NewSealMysqlMultiReader(WithDB(sealDb), WithName("test_t1"), cols, WithTimeout(time.Millisecond*10))(ctx, &ts, SealQEq("c1", 2))
+	if err == nil {
+		t.Fatal("should be timeout")
+	}
+
+	// 连续失败后熔断，到期后探测成功恢复
+	breaker := NewBreaker(WithBreakerThreshold(2, time.Millisecond*20))
+	counter := NewSealMysqlCounter(WithDB(sealDb), WithName("test_t1"), WithBreaker(breaker))
+	mock.ExpectQuery("SELECT COUNT(*) AS agg_count FROM test_t1").WillReturnError(errShutdown)
+	mock.ExpectQuery("SELECT COUNT(*) AS agg_count FROM test_t1").WillReturnError(errShutdown)
+	for _, e := range []error{errShutdown, errShutdown, ErrCircuitOpen} {
+		_, err = counter(ctx)
+		if !errors.Is(err, e) {
+			t.Fatal(e, err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatal(breaker.State())
+	}
+	time.Sleep(time.Millisecond * 30)
+	mock.ExpectQuery("SELECT COUNT(*) AS agg_count FROM test_t1").WillReturnRows(sqlmock.NewRows([]string{"agg_count"}).AddRow(3))
+	cnt, err = counter(ctx)
+	if err != nil || cnt != 3 || breaker.State() != BreakerClosed {
+		t.Fatal(cnt, err, breaker.State())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,207 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/rumis/seal/query"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrColumnMissing error = serr.New(serr.ErrInvalid, "column is missing in result")
+
+// RepoCounter 数据计数
+// @params where 查询子句
+type RepoCounter func(ctx context.Context, where ...ClauseHandler) (int64, error)
+
+// RepoExister 判断数据是否存在
+// @params where 查询子句
+type RepoExister func(ctx context.Context, where ...ClauseHandler) (bool, error)
+
+// RepoSummer 数值求和、求平均等，无数据时返回0
+// @params where 查询子句
+type RepoSummer func(ctx context.Context, where ...ClauseHandler) (float64, error)
+
+// NewSealMysqlCounter 创建新的Seal计数对象【SELECT COUNT(*)】
+func NewSealMysqlCounter(hands ...RepoSealOptionHandler) RepoCounter {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, handler ...ClauseHandler) (int64, error) {
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return 0, err
+		}
+		q := sq.Count("*").From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return 0, err
+		}
+		var cnt int64
+		err = q.Query(ctx).Agg(&cnt)
+		return cnt, err
+	}
+}
+
+// NewSealMysqlExister 创建新的Seal存在判断对象【SELECT 1 ... LIMIT 1】
+func NewSealMysqlExister(hands ...RepoSealOptionHandler) RepoExister {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, handler ...ClauseHandler) (bool, error) {
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return false, err
+		}
+		q := sq.Select("1").From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return false, err
+		}
+		rows, err := q.Limit(1).Query(ctx).AllMap()
+		return len(rows) > 0, err
+	}
+}
+
+// NewSealMysqlSummer 创建新的Seal求和对象【SELECT SUM(column)】
+func NewSealMysqlSummer(column string, hands ...RepoSealOptionHandler) RepoSummer {
+	return newSealMysqlSummer("SUM", column, hands...)
+}
+
+// NewSealMysqlAverager 创建新的Seal求平均对象【SELECT AVG(column)】
+func NewSealMysqlAverager(column string, hands ...RepoSealOptionHandler) RepoSummer {
+	return newSealMysqlSummer("AVG", column, hands...)
+}
+
+func newSealMysqlSummer(aggFn string, column string, hands ...RepoSealOptionHandler) RepoSummer {
+	agg := NewSealMysqlAggregator(aggFn, column, hands...)
+	return func(ctx context.Context, handler ...ClauseHandler) (float64, error) {
+		var val sql.NullFloat64
+		err := agg(ctx, &val, handler...)
+		return val.Float64, err
+	}
+}
+
+// NewSealMysqlAggregator 创建新的Seal聚合读取对象，如MAX、MIN
+// 结果通过Scan写入data，无数据时为NULL，可使用sql.NullInt64等类型承载
+func NewSealMysqlAggregator(aggFn string, column string, hands ...RepoSealOptionHandler) RepoReader {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return err
+		}
+		q := query.NewSelectQuery(sq.Builder(), sq).Agg(aggFn, column, "agg_"+strings.ToLower(aggFn)).From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return err
+		}
+		return q.Query(ctx).Agg(data)
+	}
+}
+
+// NewSealMysqlPlucker 创建新的Seal单列读取对象，data为切片指针，如*[]int64
+func NewSealMysqlPlucker(column string, hands ...RepoSealOptionHandler) RepoReader {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return err
+		}
+		q := sq.Select(column).From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return err
+		}
+		rows, err := q.Query(ctx).AllMap()
+		if err != nil {
+			return err
+		}
+		vals := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			for _, v := range row {
+				vals = append(vals, v)
+			}
+		}
+		return utils.Map2Struct(vals, data)
+	}
+}
+
+// NewSealMysqlMapReader 创建新的Seal数据读取对象，结果以keyColumn的值为KEY
+// data为map指针，如*map[int64]T，keyColumn需包含在Columns中，重复的KEY以最后一行为准
+func NewSealMysqlMapReader(keyColumn string, hands ...RepoSealOptionHandler) RepoReader {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
+		ctx, cancel := opts.sealTimeout(ctx)
+		defer cancel()
+		opts, sq, err := opts.sealResolve(ctx, sealOpRead)
+		if err != nil {
+			return err
+		}
+		q := sq.Select(opts.Columns...).From(sealFrom(opts))
+		err = sealApplySelect(opts, q, handler)
+		if err != nil {
+			return err
+		}
+		rows, err := q.Query(ctx).AllMap()
+		if err != nil {
+			return err
+		}
+		res := make(map[interface{}]interface{}, len(rows))
+		for _, row := range rows {
+			key, ok := row[keyColumn]
+			if !ok {
+				return ErrColumnMissing
+			}
+			if b, ok := key.([]byte); ok {
+				key = string(b)
+			}
+			res[key] = row
+		}
+		return utils.Map2Struct(res, data)
+	}
+}
+
+// sealApplySelect 应用查询子句及软删除过滤
+func sealApplySelect(opts RepoSealOptions, q *query.SelectQuery, handler []ClauseHandler) error {
+	for _, v := range handler {
+		err := v(q)
+		if err != nil {
+			return err
+		}
+	}
+	sealSoftDeleteFilter(opts, q)
+	return nil
+}
@@ -0,0 +1,85 @@
+package srepo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rumis/seal/expr"
+	"github.com/rumis/seal/query"
+)
+
+// NewSealMysqlDeleter 创建新的Seal数据删除对象
+// 配置WithSoftDelete时执行软删除，仅更新未删除数据的删除时间
+func NewSealMysqlDeleter(hands ...RepoSealOptionHandler) RepoDeleter {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, handler ...ClauseHandler) (int64, error) {
+		if len(opts.Joins) > 0 {
+			return 0, ErrJoinUnsupported
+		}
+		if len(handler) == 0 && !opts.AllowFullTable {
+			return 0, ErrDeleteWithoutWhere
+		}
+		return opts.sealRun(ctx, sealOpWrite, HookOpDelete, false, nil, handler, func(ctx context.Context, opts RepoSealOptions, sq query.Query, _ interface{}) (int64, error) {
+			if !opts.AllowFullTable {
+				ok, err := sealHasWhere(ctx, sq, opts.SoftDeleteColumn != "", handler)
+				if err != nil {
+					return 0, err
+				}
+				if !ok {
+					return 0, ErrDeleteWithoutWhere
+				}
+			}
+			return sealDelete(ctx, opts, sq, handler)
+		})
+	}
+}
+
+// sealDelete 执行删除
+func sealDelete(ctx context.Context, opts RepoSealOptions, sq query.Query, handler []ClauseHandler) (int64, error) {
+	var affectCnt int64
+	// 软删除
+	if opts.SoftDeleteColumn != "" {
+		q := sq.Update(opts.Name)
+		for _, v := range handler {
+			err := v(q)
+			if err != nil {
+				return 0, err
+			}
+		}
+		q.Where(sealNotDeleted(opts.SoftDeleteColumn))
+		err := q.Value(map[string]interface{}{opts.SoftDeleteColumn: time.Now()}).Exec(ctx, &affectCnt)
+		return affectCnt, err
+	}
+	q := sq.Delete(opts.Name)
+	for _, v := range handler {
+		err := v(q)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err := q.Exec(ctx, &affectCnt)
+	return affectCnt, err
+}
+
+// sealNotDeleted 未软删除条件
+func sealNotDeleted(column string) expr.Expr {
+	return CondIsNull(column)
+}
+
+// sealSoftDeleteFilter 读取时过滤已软删除的数据，关联读取时字段带主表名前缀
+func sealSoftDeleteFilter(opts RepoSealOptions, q *query.SelectQuery) {
+	if opts.SoftDeleteColumn == "" || opts.IncludeDeleted {
+		return
+	}
+	column := opts.SoftDeleteColumn
+	if len(opts.Joins) > 0 && !strings.Contains(column, ".") {
+		column = opts.Name + "." + column
+	}
+	q.Where(sealNotDeleted(column))
+}
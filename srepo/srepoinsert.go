@@ -3,7 +3,7 @@ package srepo
 import (
 	"context"
 
-	"github.com/rumis/seal"
+	"github.com/rumis/seal/query"
 )
 
 // NewSealMysqlInserter 创建新的Seal数据写入对象
@@ -14,29 +14,15 @@ func NewSealMysqlInserter(hands ...RepoSealOptionHandler) RepoInserter {
 	for _, fn := range hands {
 		fn(&opts)
 	}
-	// 优先TX
-	if sealTx, ok := opts.TX.(*seal.Tx); ok {
-		return func(ctx context.Context, params interface{}) (int64, error) {
-			var lastId int64
-			err := sealTx.Insert(opts.Name).Value(params).Exec(ctx, &lastId)
-			return lastId, err
-		}
-	}
-	// DB逻辑
-	if sealDb, ok := opts.DB.(seal.DB); ok {
-		return func(ctx context.Context, params interface{}) (int64, error) {
-			var lastId int64
-			err := sealDb.Insert(opts.Name).Value(params).Exec(ctx, &lastId)
-			return lastId, err
-		}
-	}
-	// error
 	return func(ctx context.Context, params interface{}) (int64, error) {
-		return 0, ErrBothDbAndTxNil
+		return opts.sealRun(ctx, sealOpWrite, HookOpInsert, false, params, nil, func(ctx context.Context, opts RepoSealOptions, sq query.Query, params interface{}) (int64, error) {
+			return sealInsert(ctx, sq, opts.Name, false, params, opts.returningColumn())
+		})
 	}
 }
 
 // NewSealMysqlMultiInserter 创建新的Seal数据写入对象-一次写入多次数据
+// 配置WithBulkChunkSize时分批写入，返回最后一批数据的最后一个自增ID
 func NewSealMysqlMultiInserter(hands ...RepoSealOptionHandler) RepoInserter {
 	// 默认配置
 	opts := DefaultRepoSealOptions()
@@ -44,24 +30,11 @@ func NewSealMysqlMultiInserter(hands ...RepoSealOptionHandler) RepoInserter {
 	for _, fn := range hands {
 		fn(&opts)
 	}
-	// 优先TX
-	if sealTx, ok := opts.TX.(*seal.Tx); ok {
-		return func(ctx context.Context, params interface{}) (int64, error) {
-			var lastId int64
-			err := sealTx.Insert(opts.Name).Values(params).Exec(ctx, &lastId)
-			return lastId, err
-		}
-	}
-	// DB逻辑
-	if sealDb, ok := opts.DB.(seal.DB); ok {
-		return func(ctx context.Context, params interface{}) (int64, error) {
-			var lastId int64
-			err := sealDb.Insert(opts.Name).Values(params).Exec(ctx, &lastId)
-			return lastId, err
-		}
-	}
-	// error
 	return func(ctx context.Context, params interface{}) (int64, error) {
-		return 0, ErrBothDbAndTxNil
+		if opts.BulkChunkSize > 0 {
+			res, err := opts.sealBulk(ctx, SliceRows(params))
+			return res.LastId, err
+		}
+		return opts.sealRun(ctx, sealOpWrite, HookOpInsert, true, params, nil, sealMultiInsert)
 	}
 }
@@ -3,7 +3,7 @@ package srepo
 import (
 	"context"
 
-	"github.com/rumis/seal"
+	"github.com/rumis/seal/query"
 )
 
 // NewSealMysqlOneReader 创建新的Seal数据写入对象
@@ -14,30 +14,18 @@ func NewSealMysqlOneReader(hands ...RepoSealOptionHandler) RepoReader {
 	for _, fn := range hands {
 		fn(&opts)
 	}
-	// 优先TX
-	if sealTx, ok := opts.TX.(*seal.Tx); ok {
-		return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
-			q := sealTx.Select(opts.Columns...).From(opts.Name)
-			for _, v := range handler {
-				v(q)
-			}
-			err := q.Limit(1).Query(ctx).OneStruct(&data)
-			return err
-		}
-	}
-	// DB逻辑
-	if sealDb, ok := opts.DB.(seal.DB); ok {
-		return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
-			q := sealDb.Select(opts.Columns...).From(opts.Name)
+	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
+		_, err := opts.sealRun(ctx, sealOpRead, HookOpRead, false, data, handler, func(ctx context.Context, opts RepoSealOptions, sq query.Query, data interface{}) (int64, error) {
+			q := sq.Select(opts.Columns...).From(sealFrom(opts))
 			for _, v := range handler {
-				v(q)
+				err := v(q)
+				if err != nil {
+					return 0, err
+				}
 			}
-			err := q.Limit(1).Query(ctx).OneStruct(&data)
-			return err
-		}
-	}
-	// error
-	return func(ctx context.Context, data interface{}, handler ...ClauseHandler) error {
-		return ErrBothDbAndTxNil
+			sealSoftDeleteFilter(opts, q)
+			return 0, q.Limit(1).Query(ctx).OneStruct(&data)
+		})
+		return err
 	}
 }
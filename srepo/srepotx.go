@@ -0,0 +1,223 @@
+package srepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/seal/options"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrTxSqlDBNil error = serr.New(serr.ErrInvalid, "tx options need sql db when isolation level or read only is set")
+var ErrTxNestedDB error = serr.New(serr.ErrInvalid, "nested tx must use the same db as the outer tx")
+var ErrTxNestedOptions error = serr.New(serr.ErrInvalid, "nested tx can not set isolation level or read only")
+
+// txContextKey 事务在context中的KEY
+type txContextKey struct{}
+
+// txState context中的事务状态
+type txState struct {
+	q         query.Query
+	db        *options.SealOptions // 开启事务的数据库，以配置对象区分不同的seal.DB
+	savepoint int                  // 已创建的savepoint数量
+}
+
+// TxOptions 事务配置
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	SqlDB     *sql.DB // seal.DB不支持指定隔离级别开启事务，设置Isolation或ReadOnly时需提供底层*sql.DB
+
+	RetryTimes int                  // 可重试错误的最大重试次数
+	RetrySpan  time.Duration        // 首次重试间隔，之后每次翻倍
+	RetryFn    func(err error) bool // 判断错误是否可重试，默认IsRetryable
+}
+
+// TxOptionHandler 事务配置选项
+type TxOptionHandler func(*TxOptions)
+
+// DefaultTxOptions 创建默认的事务配置
+func DefaultTxOptions() TxOptions {
+	return TxOptions{}
+}
+
+// WithTxIsolation 事务隔离级别
+func WithTxIsolation(level sql.IsolationLevel) TxOptionHandler {
+	return func(opts *TxOptions) {
+		opts.Isolation = level
+	}
+}
+
+// WithTxReadOnly 只读事务
+func WithTxReadOnly() TxOptionHandler {
+	return func(opts *TxOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+// WithTxSqlDB 底层*sql.DB，需与WithinTx的db参数为同一个库
+func WithTxSqlDB(db *sql.DB) TxOptionHandler {
+	return func(opts *TxOptions) {
+		opts.SqlDB = db
+	}
+}
+
+// WithTxRetry 事务返回可重试的错误(死锁、锁等待超时等)时回滚并重新执行整个事务，fn需可重复执行
+// 嵌套事务不重试
+func WithTxRetry(times int, span time.Duration) TxOptionHandler {
+	return func(opts *TxOptions) {
+		opts.RetryTimes = times
+		opts.RetrySpan = span
+	}
+}
+
+// WithTxRetryFn 判断事务错误是否可重试
+func WithTxRetryFn(fn func(err error) bool) TxOptionHandler {
+	return func(opts *TxOptions) {
+		opts.RetryFn = fn
+	}
+}
+
+// TxFromContext 获取context中的事务
+func TxFromContext(ctx context.Context) (query.Query, bool) {
+	st, ok := ctx.Value(txContextKey{}).(*txState)
+	if !ok {
+		return query.Query{}, false
+	}
+	return st.q, true
+}
+
+// WithinTx 在事务中执行fn
+// fn返回错误或panic时回滚，否则提交；事务保存在fn的ctx中，srepo的读写对象会自动使用。
+// 嵌套调用时使用savepoint，内层失败只回滚到对应的savepoint；
+// 嵌套调用的db需与外层相同，且不能设置隔离级别或只读，否则返回ErrTxNestedDB、ErrTxNestedOptions
+func WithinTx(ctx context.Context, db seal.DB, fn func(ctx context.Context) error, hands ...TxOptionHandler) (err error) {
+	// 默认配置
+	opts := DefaultTxOptions()
+	// 自定义配置设置
+	for _, h := range hands {
+		h(&opts)
+	}
+	// 嵌套事务
+	if st, ok := ctx.Value(txContextKey{}).(*txState); ok {
+		if st.db != nil && st.db != db.Options() {
+			return ErrTxNestedDB
+		}
+		if opts.Isolation != sql.LevelDefault || opts.ReadOnly {
+			return ErrTxNestedOptions
+		}
+		return withinSavepoint(ctx, st, fn)
+	}
+	return sealRetry(ctx, opts.RetryTimes, opts.RetrySpan, opts.RetryFn, func() error {
+		return withinTxOnce(ctx, db, opts, fn)
+	})
+}
+
+// withinTxOnce 开启事务执行fn
+func withinTxOnce(ctx context.Context, db seal.DB, opts TxOptions, fn func(ctx context.Context) error) (err error) {
+	var q query.Query
+	var commit, rollback func() error
+	txOpts := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+	if pq, tx, ok, err := sealBeginPostgres(ctx, db, txOpts); ok {
+		// PostgreSQL需转换占位符，直接在底层连接上开启事务
+		if err != nil {
+			return err
+		}
+		q, commit, rollback = pq, tx.Commit, tx.Rollback
+	} else if opts.Isolation == sql.LevelDefault && !opts.ReadOnly {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		q, commit, rollback = tx.Query, tx.Commit, tx.Rollback
+	} else {
+		if opts.SqlDB == nil {
+			return ErrTxSqlDBNil
+		}
+		tx, err := opts.SqlDB.BeginTx(ctx, txOpts)
+		if err != nil {
+			return err
+		}
+		q, commit, rollback = query.NewQuery(db.Builder(), tx, db.Options()), tx.Commit, tx.Rollback
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+		if err != nil {
+			rollback()
+			return
+		}
+		err = commit()
+	}()
+	return fn(context.WithValue(ctx, txContextKey{}, &txState{q: q, db: db.Options()}))
+}
+
+// withinSavepoint 在savepoint中执行fn
+func withinSavepoint(ctx context.Context, st *txState, fn func(ctx context.Context) error) (err error) {
+	st.savepoint++
+	name := fmt.Sprintf("srepo_sp_%d", st.savepoint)
+	_, err = st.q.ExecContext(ctx, "SAVEPOINT "+name).RowsAffected()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			st.q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+		if err != nil {
+			st.q.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			return
+		}
+		_, err = st.q.ExecContext(ctx, "RELEASE SAVEPOINT "+name).RowsAffected()
+	}()
+	return fn(ctx)
+}
+
+// sealOp 操作类型，用于读写分离
+type sealOp int8
+
+const (
+	sealOpRead  sealOp = 1
+	sealOpWrite sealOp = 2
+)
+
+// sealQuery 获取执行对象
+// 优先级：WithTX > context中的事务 > WithRouter > WithDB
+func (opts RepoSealOptions) sealQuery(ctx context.Context, op sealOp) (query.Query, error) {
+	if opts.Err != nil {
+		return query.Query{}, opts.Err
+	}
+	if sealTx, ok := opts.TX.(*seal.Tx); ok {
+		return opts.sealGuard(sealDialectTx(sealTx.Query), op, true), nil
+	}
+	if q, ok := TxFromContext(ctx); ok {
+		return opts.sealGuard(q, op, true), nil
+	}
+	if opts.Router != nil {
+		if op == sealOpWrite {
+			return opts.sealGuard(opts.Router.Write(ctx), op, false), nil
+		}
+		return opts.sealGuard(opts.Router.Read(ctx), op, false), nil
+	}
+	if sealDb, ok := opts.DB.(seal.DB); ok {
+		return opts.sealGuard(sealDb.Query, op, false), nil
+	}
+	return query.Query{}, ErrBothDbAndTxNil
+}
+
+// sealTxDB 开启事务使用的数据库，读写分离时为主库
+func (opts RepoSealOptions) sealTxDB() (seal.DB, bool) {
+	if opts.Router != nil {
+		return opts.Router.Primary(), true
+	}
+	db, ok := opts.DB.(seal.DB)
+	return db, ok
+}
@@ -0,0 +1,150 @@
+package srepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/rumis/seal"
+	"github.com/rumis/storage/meta"
+	"github.com/rumis/storage/serr"
+)
+
+// 错误定义
+var ErrUnitStepNil error = serr.New(serr.ErrInvalid, "unit step is nil")
+var ErrUnitStepDuplicate error = serr.New(serr.ErrInvalid, "unit step name is duplicated")
+
+// UnitResults 已执行步骤的结果，KEY为步骤名称，写入为最后一个自增ID，更新及删除为影响行数
+type UnitResults map[string]int64
+
+// UnitStep 步骤，res为之前步骤的结果，返回值保存为本步骤的结果
+type UnitStep func(ctx context.Context, res UnitResults) (int64, error)
+
+// RepoUnit 在一个事务中按顺序执行全部步骤，任一步骤失败时回滚并返回该错误
+type RepoUnit func(ctx context.Context) (UnitResults, error)
+
+// unitStep 已注册的步骤
+type unitStep struct {
+	name string
+	fn   UnitStep
+}
+
+// RepoUnitOptions 组合操作配置
+type RepoUnitOptions struct {
+	Steps       []unitStep
+	TxOptions   []TxOptionHandler
+	ExecLogFunc meta.RepoExecLogFunc
+}
+
+// RepoUnitOptionHandler 组合操作配置选项
+type RepoUnitOptionHandler func(*RepoUnitOptions)
+
+// DefaultRepoUnitOptions 创建默认的组合操作配置
+func DefaultRepoUnitOptions() RepoUnitOptions {
+	return RepoUnitOptions{}
+}
+
+// WithStep 注册步骤，按注册顺序执行，name在结果中唯一
+func WithStep(name string, fn UnitStep) RepoUnitOptionHandler {
+	return func(opts *RepoUnitOptions) {
+		opts.Steps = append(opts.Steps, unitStep{name: name, fn: fn})
+	}
+}
+
+// WithUnitTx 事务配置，如WithTxRetry重试时全部步骤重新执行
+func WithUnitTx(hands ...TxOptionHandler) RepoUnitOptionHandler {
+	return func(opts *RepoUnitOptions) {
+		opts.TxOptions = append(opts.TxOptions, hands...)
+	}
+}
+
+// WithExecLogger 日志函数，每个步骤记录一次，args为步骤名称
+func WithExecLogger(fn meta.RepoExecLogFunc) RepoUnitOptionHandler {
+	return func(opts *RepoUnitOptions) {
+		opts.ExecLogFunc = fn
+	}
+}
+
+// NewRepoUnit 创建组合操作，db为开启事务的数据库，读写分离时为主库
+// 步骤中的读写对象自动使用context中的事务，ctx已在事务中时使用savepoint
+func NewRepoUnit(db seal.DB, hands ...RepoUnitOptionHandler) RepoUnit {
+	// 默认配置
+	opts := DefaultRepoUnitOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context) (UnitResults, error) {
+		names := make(map[string]bool, len(opts.Steps))
+		for _, step := range opts.Steps {
+			if step.fn == nil {
+				return nil, ErrUnitStepNil
+			}
+			if names[step.name] {
+				return nil, ErrUnitStepDuplicate
+			}
+			names[step.name] = true
+		}
+		var res UnitResults
+		err := WithinTx(ctx, db, func(ctx context.Context) error {
+			// 事务重试时结果重新计算
+			res = make(UnitResults, len(opts.Steps))
+			for _, step := range opts.Steps {
+				startTime := time.Now()
+				val, err := step.fn(ctx, res)
+				if opts.ExecLogFunc != nil {
+					opts.ExecLogFunc(ctx, time.Since(startTime), step.name, err)
+				}
+				if err != nil {
+					return err
+				}
+				res[step.name] = val
+			}
+			return nil
+		}, opts.TxOptions...)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+// InsertStep 写入步骤，data根据之前步骤的结果生成写入的数据，结果为最后一个自增ID
+func InsertStep(fn RepoInserter, data func(res UnitResults) interface{}) UnitStep {
+	return func(ctx context.Context, res UnitResults) (int64, error) {
+		return fn(ctx, data(res))
+	}
+}
+
+// UpdateStep 更新步骤，结果为影响行数，影响行数为0时返回ErrUpdateAffectZeroRows
+// @params where 根据之前步骤的结果生成更新条件，可为nil
+func UpdateStep(fn RepoUpdater, data func(res UnitResults) interface{}, where func(res UnitResults) []ClauseHandler) UnitStep {
+	return func(ctx context.Context, res UnitResults) (int64, error) {
+		cnt, err := fn(ctx, data(res), unitWhere(where, res)...)
+		if err == nil && cnt == 0 {
+			return 0, ErrUpdateAffectZeroRows
+		}
+		return cnt, err
+	}
+}
+
+// DeleteStep 删除步骤，结果为影响行数
+func DeleteStep(fn RepoDeleter, where func(res UnitResults) []ClauseHandler) UnitStep {
+	return func(ctx context.Context, res UnitResults) (int64, error) {
+		return fn(ctx, unitWhere(where, res)...)
+	}
+}
+
+// ReadStep 读取步骤，数据写入data，结果为0
+func ReadStep(fn RepoReader, data interface{}, where func(res UnitResults) []ClauseHandler) UnitStep {
+	return func(ctx context.Context, res UnitResults) (int64, error) {
+		return 0, fn(ctx, data, unitWhere(where, res)...)
+	}
+}
+
+// unitWhere 生成步骤的条件
+func unitWhere(where func(res UnitResults) []ClauseHandler, res UnitResults) []ClauseHandler {
+	if where == nil {
+		return nil
+	}
+	return where(res)
+}
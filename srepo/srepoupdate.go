@@ -4,6 +4,9 @@ import (
 	"context"
 
 	"github.com/rumis/seal"
+	"github.com/rumis/seal/expr"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/seal/utils"
 )
 
 // NewSealMysqlMultiReader 创建新的Seal数据读取对象，返回值多行
@@ -14,32 +17,136 @@ func NewSealMysqlUpdater(hands ...RepoSealOptionHandler) RepoUpdater {
 	for _, fn := range hands {
 		fn(&opts)
 	}
-	// 优先TX
-	if sealTx, ok := opts.TX.(*seal.Tx); ok {
-		return func(ctx context.Context, param interface{}, handler ...ClauseHandler) (int64, error) {
+	return func(ctx context.Context, param interface{}, handler ...ClauseHandler) (int64, error) {
+		if len(opts.Joins) > 0 {
+			return 0, ErrJoinUnsupported
+		}
+		if len(handler) == 0 && !opts.AllowFullTable {
+			return 0, ErrUpdateWithoutWhere
+		}
+		return opts.sealRun(ctx, sealOpWrite, HookOpUpdate, false, param, handler, func(ctx context.Context, opts RepoSealOptions, sq query.Query, param interface{}) (int64, error) {
+			if !opts.AllowFullTable {
+				ok, err := sealHasWhere(ctx, sq, true, handler)
+				if err != nil {
+					return 0, err
+				}
+				if !ok {
+					return 0, ErrUpdateWithoutWhere
+				}
+			}
 			var affectCnt int64
-			q := sealTx.Update(opts.Name)
+			q := sq.Update(opts.Name)
 			for _, v := range handler {
-				v(q)
+				err := v(q)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if len(handler) == 0 {
+				// seal要求更新语句必须带条件
+				q.Where(CondRaw("1=1"))
+			}
+			param, err := sealFencing(opts, q, param)
+			if err != nil {
+				return 0, err
+			}
+			param, err = sealVersion(opts, q, param)
+			if err != nil {
+				return 0, err
+			}
+			err = q.Value(param).Exec(ctx, &affectCnt)
+			if err == nil && affectCnt == 0 && opts.FenceColumn != "" {
+				// 影响行数为0也可能是数据不存在或数据未变化，重新读取token区分
+				rejected, err := sealFenceRejected(ctx, opts, sq, handler)
+				if err != nil {
+					return 0, err
+				}
+				if rejected {
+					return 0, ErrFenceTokenRejected
+				}
+			}
+			if err == nil && affectCnt == 0 && opts.VersionColumn != "" {
+				return 0, ErrVersionConflict
 			}
-			err := q.Value(param).Exec(ctx, &affectCnt)
 			return affectCnt, err
-		}
+		})
 	}
-	// DB逻辑
-	if sealDb, ok := opts.DB.(seal.DB); ok {
-		return func(ctx context.Context, param interface{}, handler ...ClauseHandler) (int64, error) {
-			var affectCnt int64
-			q := sealDb.Update(opts.Name)
-			for _, v := range handler {
-				v(q)
-			}
-			err := q.Value(param).Exec(ctx, &affectCnt)
+}
+
+// sealFencing 附加fencing token条件，并将token写入更新数据
+func sealFencing(opts RepoSealOptions, q *query.UpdateQuery, param interface{}) (interface{}, error) {
+	if opts.FenceColumn == "" {
+		return param, nil
+	}
+	vals, err := sealUpdateMap(param)
+	if err != nil {
+		return nil, err
+	}
+	vals[opts.FenceColumn] = opts.FenceToken
+	q.Where(seal.Op(opts.FenceColumn, "<=", opts.FenceToken))
+	return vals, nil
+}
+
+// sealFenceRejected 更新条件匹配的数据中是否存在大于当前token的fencing token
+func sealFenceRejected(ctx context.Context, opts RepoSealOptions, sq query.Query, handler []ClauseHandler) (bool, error) {
+	q := sq.Select("1").From(sealFrom(opts))
+	err := sealApplySelect(opts, q, handler)
+	if err != nil {
+		return false, err
+	}
+	q.Where(seal.Op(opts.FenceColumn, ">", opts.FenceToken))
+	rows, err := q.Limit(1).Query(ctx).AllMap()
+	return len(rows) > 0, err
+}
+
+// sealVersion 附加版本号条件，并将版本号加1
+func sealVersion(opts RepoSealOptions, q *query.UpdateQuery, param interface{}) (interface{}, error) {
+	if opts.VersionColumn == "" {
+		return param, nil
+	}
+	vals, err := sealUpdateMap(param)
+	if err != nil {
+		return nil, err
+	}
+	version, ok := vals[opts.VersionColumn]
+	if !ok {
+		return nil, ErrVersionNil
+	}
+	vals[opts.VersionColumn] = expr.New(opts.VersionColumn + "+1")
+	q.Where(seal.Eq(opts.VersionColumn, version))
+	return vals, nil
+}
+
+// RetryOnVersionConflict 乐观锁更新，版本冲突时重新读取数据并重新执行修改
+// 每次尝试依次调用reader读取最新数据(含版本号)、mutate修改数据、updater更新，最多尝试attempts次
+func RetryOnVersionConflict(ctx context.Context, attempts int, reader RepoReader, updater RepoUpdater, data interface{}, mutate func(data interface{}) error, where ...ClauseHandler) (int64, error) {
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = reader(ctx, data, where...)
+		if err != nil {
+			return 0, err
+		}
+		err = mutate(data)
+		if err != nil {
+			return 0, err
+		}
+		var affectCnt int64
+		affectCnt, err = updater(ctx, data, where...)
+		if err != ErrVersionConflict {
 			return affectCnt, err
 		}
 	}
-	// error
-	return func(ctx context.Context, param interface{}, handler ...ClauseHandler) (int64, error) {
-		return 0, ErrBothDbAndTxNil
+	return 0, err
+}
+
+// sealUpdateMap 将更新数据转换为map，map类型会复制一份，避免修改调用方数据
+func sealUpdateMap(param interface{}) (map[string]interface{}, error) {
+	if m, ok := param.(map[string]interface{}); ok {
+		vals := make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			vals[k] = v
+		}
+		return vals, nil
 	}
+	return utils.Struct2Map(param)
 }
@@ -0,0 +1,243 @@
+package srepo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rumis/seal/builder"
+	"github.com/rumis/seal/query"
+	"github.com/rumis/seal/utils"
+	"github.com/rumis/storage/serr"
+)
+
+var ErrUpsertColumnsNil error = serr.New(serr.ErrInvalid, "upsert update columns is nil")
+
+// UpsertResult 插入或更新结果
+//
+// MySQL的affected rows中新插入的行计1，更新的行计2，值未变化的行计0，
+// 多行写入时若affected rows不小于行数，按不存在值未变化的行估算Inserted和Updated；
+// PostgreSQL按行返回是否新插入，值未变化的行计入Updated；SQLite插入或更新时仅返回Affected
+type UpsertResult struct {
+	LastId    int64 // 最后一个自增ID
+	Affected  int64 // 影响行数
+	Inserted  int64 // 新插入的行数
+	Updated   int64 // 更新的行数
+	Unchanged int64 // 主键冲突但值未变化的行数
+	Ignored   int64 // INSERT IGNORE(ON CONFLICT DO NOTHING)忽略的行数
+}
+
+// RepoUpserter 数据插入或更新
+// @params data 需要插入的数据，支持单个数据或者数组
+type RepoUpserter func(ctx context.Context, data interface{}) (UpsertResult, error)
+
+// upsertMode 写入模式
+type upsertMode int8
+
+const (
+	upsertModeUpdate upsertMode = 1 // INSERT ... ON DUPLICATE KEY UPDATE
+	upsertModeIgnore upsertMode = 2 // INSERT IGNORE
+)
+
+// NewSealMysqlUpserter 创建新的Seal数据插入或更新对象，主键冲突时更新WithUpsertColumns指定的字段
+// PostgreSQL及SQLite需通过WithUpsertKeyColumns或WithUpsertAllColumns指定冲突字段
+func NewSealMysqlUpserter(hands ...RepoSealOptionHandler) RepoUpserter {
+	return newSealMysqlUpserter(upsertModeUpdate, false, hands...)
+}
+
+// NewSealMysqlMultiUpserter 创建新的Seal数据插入或更新对象-一次写入多条数据
+func NewSealMysqlMultiUpserter(hands ...RepoSealOptionHandler) RepoUpserter {
+	return newSealMysqlUpserter(upsertModeUpdate, true, hands...)
+}
+
+// NewSealMysqlInsertIgnorer 创建新的Seal数据写入对象，主键冲突时忽略
+func NewSealMysqlInsertIgnorer(hands ...RepoSealOptionHandler) RepoUpserter {
+	return newSealMysqlUpserter(upsertModeIgnore, false, hands...)
+}
+
+// NewSealMysqlMultiInsertIgnorer 创建新的Seal数据写入对象，主键冲突时忽略-一次写入多条数据
+func NewSealMysqlMultiInsertIgnorer(hands ...RepoSealOptionHandler) RepoUpserter {
+	return newSealMysqlUpserter(upsertModeIgnore, true, hands...)
+}
+
+func newSealMysqlUpserter(mode upsertMode, multi bool, hands ...RepoSealOptionHandler) RepoUpserter {
+	// 默认配置
+	opts := DefaultRepoSealOptions()
+	// 自定义配置设置
+	for _, fn := range hands {
+		fn(&opts)
+	}
+	return func(ctx context.Context, params interface{}) (UpsertResult, error) {
+		var ur UpsertResult
+		_, err := opts.sealRun(ctx, sealOpWrite, HookOpInsert, multi, params, nil, func(ctx context.Context, opts RepoSealOptions, sq query.Query, params interface{}) (int64, error) {
+			var err error
+			ur, err = sealUpsert(ctx, opts, sq, mode, multi, params)
+			return ur.LastId, err
+		})
+		return ur, err
+	}
+}
+
+// sealUpsert 执行插入或更新
+func sealUpsert(ctx context.Context, opts RepoSealOptions, sq query.Query, mode upsertMode, multi bool, params interface{}) (UpsertResult, error) {
+	bi := builder.NewInsert(sq.Builder(), sq.Options().EncodeHook).Into(opts.Name)
+	rowCnt := int64(1)
+	if multi {
+		bi.Values(params)
+		if v := reflect.Indirect(reflect.ValueOf(params)); v.Kind() == reflect.Slice {
+			rowCnt = int64(v.Len())
+		}
+	} else {
+		bi.Value(params)
+	}
+	sql, args, err := bi.ToSql()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	dialect := DialectOf(sq.Builder())
+	var cols []string
+	if mode == upsertModeUpdate {
+		cols, err = sealUpsertColumns(opts, params, multi)
+		if err != nil {
+			return UpsertResult{}, err
+		}
+		if dialect != DialectMysql && len(opts.UpsertKeyColumns) == 0 {
+			return UpsertResult{}, ErrUpsertKeyColumnsNil
+		}
+	}
+	if dialect == DialectPostgres {
+		return sealUpsertPostgres(ctx, opts, sq, mode, rowCnt, sql, args, cols)
+	}
+	switch {
+	case dialect == DialectSqlite && mode == upsertModeIgnore:
+		sql = "INSERT OR IGNORE" + strings.TrimPrefix(sql, "INSERT")
+	case dialect == DialectSqlite && mode == upsertModeUpdate:
+		sql += sealOnConflict(opts.UpsertKeyColumns, cols)
+	case mode == upsertModeIgnore:
+		sql = "INSERT IGNORE" + strings.TrimPrefix(sql, "INSERT")
+	case mode == upsertModeUpdate:
+		sets := make([]string, 0, len(cols))
+		for _, c := range cols {
+			sets = append(sets, c+"=VALUES("+c+")")
+		}
+		sql += " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+	res := sq.ExecContext(ctx, sql, args...)
+	var ur UpsertResult
+	ur.Affected, err = res.RowsAffected()
+	if err != nil {
+		return ur, err
+	}
+	ur.LastId, err = res.LastInsertId()
+	if err != nil {
+		return ur, err
+	}
+	switch {
+	case mode == upsertModeIgnore:
+		ur.Inserted = ur.Affected
+		ur.Ignored = rowCnt - ur.Affected
+	case dialect == DialectSqlite:
+		// SQLite插入及更新的行均计1，无法区分
+	case ur.Affected >= rowCnt:
+		ur.Updated = ur.Affected - rowCnt
+		ur.Inserted = rowCnt - ur.Updated
+	default:
+		ur.Inserted = ur.Affected
+		ur.Unchanged = rowCnt - ur.Affected
+	}
+	return ur, nil
+}
+
+// sealUpsertPostgres PostgreSQL插入或更新，通过RETURNING获取自增ID，xmax为0的行为新插入的行
+func sealUpsertPostgres(ctx context.Context, opts RepoSealOptions, sq query.Query, mode upsertMode, rowCnt int64, sql string, args []interface{}, cols []string) (UpsertResult, error) {
+	if mode == upsertModeIgnore {
+		sql += " ON CONFLICT DO NOTHING"
+	} else {
+		sql += sealOnConflict(opts.UpsertKeyColumns, cols)
+	}
+	returning := opts.returningColumn()
+	if returning == "" {
+		sql += " RETURNING (xmax = 0)"
+	} else {
+		sql += " RETURNING " + returning + ", (xmax = 0)"
+	}
+	rows, err := sealReturning(ctx, sq, sql, args)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	var ur UpsertResult
+	ur.Affected = int64(len(rows))
+	for _, row := range rows {
+		if inserted, _ := row[len(row)-1].(bool); inserted {
+			ur.Inserted++
+		}
+	}
+	ur.Updated = ur.Affected - ur.Inserted
+	if mode == upsertModeIgnore {
+		ur.Ignored = rowCnt - ur.Affected
+	}
+	if len(rows) > 0 && returning != "" {
+		ur.LastId, err = sealInt64(rows[len(rows)-1][0])
+	}
+	return ur, err
+}
+
+// sealOnConflict PostgreSQL及SQLite的冲突更新子句
+func sealOnConflict(keys []string, cols []string) string {
+	sets := make([]string, 0, len(cols))
+	for _, c := range cols {
+		sets = append(sets, c+"=EXCLUDED."+c)
+	}
+	return " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// sealUpsertColumns 主键冲突时需要更新的字段
+func sealUpsertColumns(opts RepoSealOptions, params interface{}, multi bool) ([]string, error) {
+	if len(opts.UpsertColumns) > 0 {
+		return opts.UpsertColumns, nil
+	}
+	if !opts.UpsertAllColumns {
+		return nil, ErrUpsertColumnsNil
+	}
+	// 除主键外的全部写入字段
+	var row map[string]interface{}
+	if multi {
+		rows, ok := params.([]map[string]interface{})
+		if !ok {
+			var err error
+			rows, err = utils.Struct2MapSlice(params)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(rows) > 0 {
+			row = rows[0]
+		}
+	} else {
+		var ok bool
+		row, ok = params.(map[string]interface{})
+		if !ok {
+			var err error
+			row, err = utils.Struct2Map(params)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	keys := make(map[string]bool, len(opts.UpsertKeyColumns))
+	for _, k := range opts.UpsertKeyColumns {
+		keys[k] = true
+	}
+	res := make([]string, 0, len(row))
+	for c := range row {
+		if !keys[c] {
+			res = append(res, c)
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrUpsertColumnsNil
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,6 @@
+-- 用户表; 迁移测试
+CREATE TABLE test_user (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(64) NOT NULL DEFAULT ';'
+);
+CREATE INDEX idx_test_user_name ON test_user (name);
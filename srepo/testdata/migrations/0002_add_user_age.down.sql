@@ -0,0 +1 @@
+ALTER TABLE test_user DROP COLUMN age;
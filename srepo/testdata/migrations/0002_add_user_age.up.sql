@@ -0,0 +1 @@
+ALTER TABLE test_user ADD COLUMN age INTEGER NOT NULL DEFAULT 0;
@@ -1,11 +1,15 @@
 package test
 
 type Person struct {
-	ID   int    `json:"id" seal:"id"`
+	ID   int    `json:"id" seal:"id,pk,autoincr"`
 	Name string `json:"name" seal:"name"`
 	Age  int    `json:"age" seal:"age"`
 }
 
+func (p *Person) TableName() string {
+	return "tal_test_person"
+}
+
 func (p *Person) Zero() bool {
 	return p.ID == 0
 }